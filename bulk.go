@@ -0,0 +1,106 @@
+package containerimagelisting
+
+import (
+	"context"
+	"sync"
+)
+
+// BulkListTagsResult is the answer of RegistryFinder.ListTagsBulk.  Both maps are keyed by the repositories exactly
+// as they were passed in, and every repository is in exactly one of them.
+type BulkListTagsResult struct {
+	Tags   map[string][]Tag
+	Errors map[string]error
+}
+
+func (r *RegistryFinder) concurrencyPerHost() int {
+	switch {
+	case r.ConcurrencyPerHost == 0:
+		return 4
+	case r.ConcurrencyPerHost < 0:
+		return 1
+	}
+	return r.ConcurrencyPerHost
+}
+
+// bulkJob lists tags once for every input that names the same repository
+type bulkJob struct {
+	host   string
+	inputs []string
+	tags   []Tag
+	err    error
+}
+
+// ListTagsBulk lists the tags of many repositories at once, with at most ConcurrencyPerHost requests in flight per
+// registry host.  Repositories that are written differently but are the same, like redis and docker.io/library/redis:7,
+// are only listed once.  Repositories that were not listed before ctx was done have ctx.Err() as their error.
+func (r *RegistryFinder) ListTagsBulk(ctx context.Context, repositories []string) *BulkListTagsResult {
+	ret := &BulkListTagsResult{
+		Tags:   make(map[string][]Tag),
+		Errors: make(map[string]error),
+	}
+	jobs := make(map[string]*bulkJob)
+	order := make([]*bulkJob, 0, len(repositories))
+	seen := make(map[string]bool)
+	for _, repository := range repositories {
+		if seen[repository] {
+			continue
+		}
+		seen[repository] = true
+		ref, err := ParseImageReference(repository)
+		if err != nil {
+			ret.Errors[repository] = err
+			continue
+		}
+		host := referenceHost(ref)
+		key := host + "/" + ref.Path
+		if registry, located := r.findRegistry(ref); registry != nil {
+			key = host + "/" + located
+		}
+		job, exists := jobs[key]
+		if !exists {
+			job = &bulkJob{host: host}
+			jobs[key] = job
+			order = append(order, job)
+		}
+		job.inputs = append(job.inputs, repository)
+	}
+
+	hostLimits := make(map[string]chan struct{})
+	for _, job := range order {
+		if _, exists := hostLimits[job.host]; !exists {
+			hostLimits[job.host] = make(chan struct{}, r.concurrencyPerHost())
+		}
+	}
+	var wg sync.WaitGroup
+	for _, job := range order {
+		wg.Add(1)
+		go func(job *bulkJob) {
+			defer wg.Done()
+			limit := hostLimits[job.host]
+			select {
+			case limit <- struct{}{}:
+			case <-ctx.Done():
+				job.err = ctx.Err()
+				return
+			}
+			defer func() { <-limit }()
+			if err := ctx.Err(); err != nil {
+				job.err = err
+				return
+			}
+			job.tags, job.err = r.ListTags(ctx, job.inputs[0])
+		}(job)
+	}
+	wg.Wait()
+
+	for _, job := range order {
+		for _, input := range job.inputs {
+			if job.err != nil {
+				ret.Errors[input] = job.err
+				continue
+			}
+			ret.Tags[input] = job.tags
+		}
+	}
+	return ret
+}
@@ -0,0 +1,88 @@
+package containerimagelisting
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryFinder_ListTagsBulk(t *testing.T) {
+	var mu sync.Mutex
+	asked := make(map[string]int)
+	inFlight := make(map[string]int)
+	maxInFlight := make(map[string]int)
+	registry := func(host string) Registry {
+		return &registryFunc{listTags: func(ctx context.Context, repository string) ([]Tag, error) {
+			mu.Lock()
+			asked[host+"/"+repository]++
+			inFlight[host]++
+			if inFlight[host] > maxInFlight[host] {
+				maxInFlight[host] = inFlight[host]
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			inFlight[host]--
+			mu.Unlock()
+			if repository == "missing" {
+				return nil, &StatusError{StatusCode: 404}
+			}
+			return []Tag{&staticTag{tag: repository}}, nil
+		}}
+	}
+	finder := RegistryFinder{
+		Registries: []RegistryWithFinder{
+			{Registry: registry("ghcr.io"), RepositoryLocator: &MultiURLHostMatcher{ValidDomains: []string{"ghcr.io"}}},
+			{Registry: registry("docker.io"), RepositoryLocator: &DockerHubLocator{}},
+		},
+		ConcurrencyPerHost: 2,
+	}
+	repositories := []string{"redis", "docker.io/library/redis:7", "redis", "ghcr.io/missing", "ghcr.io/a/b:1", "Invalid"}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		repositories = append(repositories, "cresta/"+name)
+	}
+	res := finder.ListTagsBulk(context.Background(), repositories)
+
+	require.Len(t, res.Tags, 8)
+	require.Len(t, res.Errors, 2)
+	require.Equal(t, []string{"library/redis"}, tagNames(res.Tags["redis"]))
+	require.Equal(t, []string{"library/redis"}, tagNames(res.Tags["docker.io/library/redis:7"]))
+	require.Equal(t, []string{"a/b"}, tagNames(res.Tags["ghcr.io/a/b:1"]))
+	require.ErrorIs(t, res.Errors["ghcr.io/missing"], ErrRepositoryNotFound)
+	require.ErrorIs(t, res.Errors["Invalid"], ErrInvalidReference)
+	require.Equal(t, 1, asked["docker.io/library/redis"])
+	require.Equal(t, 2, maxInFlight["docker.io"])
+
+	// Negative values are one at a time, not a panic
+	finder.ConcurrencyPerHost = -1
+	maxInFlight = make(map[string]int)
+	res = finder.ListTagsBulk(context.Background(), repositories)
+	require.Len(t, res.Tags, 8)
+	require.Equal(t, 1, maxInFlight["docker.io"])
+}
+
+func TestRegistryFinder_ListTagsBulkCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	finder := RegistryFinder{
+		Registries: []RegistryWithFinder{
+			{
+				Registry: &registryFunc{listTags: func(ctx context.Context, repository string) ([]Tag, error) {
+					cancel()
+					<-ctx.Done()
+					return nil, ctx.Err()
+				}},
+				RepositoryLocator: &DockerHubLocator{},
+			},
+		},
+		ConcurrencyPerHost: 1,
+	}
+	res := finder.ListTagsBulk(ctx, []string{"cresta/a", "cresta/b", "cresta/c"})
+	require.Empty(t, res.Tags)
+	require.Len(t, res.Errors, 3)
+	for _, err := range res.Errors {
+		require.ErrorIs(t, err, context.Canceled)
+	}
+}
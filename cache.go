@@ -0,0 +1,372 @@
+package containerimagelisting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache stores what registries returned, so later calls, including ones from other processes, can reuse it.  Values
+// are anything encoding/json can encode.  Registries only use the cache to save requests, so they carry on without it
+// when it fails.
+type Cache interface {
+	// Get decodes the value stored for key into value.  It returns CacheStale, and still decodes the value, if the value
+	// is past its TTL.
+	Get(ctx context.Context, key string, value interface{}) (CacheStatus, error)
+	// Set stores value for key.  A ttl of zero keeps the value forever.
+	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+}
+
+// CacheStatus is whether Cache.Get found a value, and if it is still fresh
+type CacheStatus int
+
+const (
+	// CacheMiss means nothing is stored for the key
+	CacheMiss CacheStatus = iota
+	// CacheStale means the value is past its TTL
+	CacheStale
+	// CacheFresh means the value is within its TTL
+	CacheFresh
+)
+
+// cacheKey joins the parts of a key, like the kind of value, the registry host and the repository
+func cacheKey(parts ...string) string {
+	return strings.Join(parts, "/")
+}
+
+// isDigest returns true if reference is a digest, like sha256:abc..., instead of a tag
+func isDigest(reference string) bool {
+	return strings.Contains(reference, ":")
+}
+
+// cacheValidators are the ETag and Last-Modified headers a registry sent with a response.  Sending them back lets the
+// registry answer 304 Not Modified, without a body, if the response did not change.
+type cacheValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// setHeaders adds If-None-Match and If-Modified-Since to header
+func (v cacheValidators) setHeaders(header http.Header) {
+	if v.ETag != "" {
+		header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		header.Set("If-Modified-Since", v.LastModified)
+	}
+}
+
+// validatorsOf returns the validators in a response's header
+func validatorsOf(header http.Header) cacheValidators {
+	return cacheValidators{
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+	}
+}
+
+// notModifiedValidators returns the validators of a 304 response, which does not have to repeat them, falling back to
+// the ones that were sent
+func notModifiedValidators(header http.Header, sent cacheValidators) cacheValidators {
+	if ret := validatorsOf(header); ret != (cacheValidators{}) {
+		return ret
+	}
+	return sent
+}
+
+// DiskCache is a Cache that stores each value as a JSON file in the entries directory of Dir, at a path made from the
+// key, like entries/v2/tags/ghcr.io/cresta/app.json for the tags of ghcr.io/cresta/app.  Files are written atomically, so readers never
+// see half written values, and writers of the same key take a lock file, so many processes, like parallel CI jobs, can
+// share one directory.
+type DiskCache struct {
+	// Dir is where files are stored, like DefaultDiskCacheDir.  It is required, and created if it does not exist.  Only
+	// files in its entries directory are ever evicted.
+	Dir string
+	// MaxSize is how many bytes the cache files may use before the least recently used ones are removed.  Defaults to
+	// 100 MiB.
+	MaxSize int64
+	// LockTimeout is how long to wait for another writer's lock.  Locks older than this are assumed to be left over
+	// from a process that died, and are removed.  Defaults to 10 seconds.
+	LockTimeout time.Duration
+	// now returns the current time.  Replaced by tests.
+	now func() time.Time
+
+	mu sync.Mutex
+	// writtenSinceEvict is how many bytes this process wrote since it last checked MaxSize
+	writtenSinceEvict int64
+	evictChecked      bool
+}
+
+var _ Cache = &DiskCache{}
+
+// DefaultDiskCacheDir returns container-image-listing inside the user's cache directory, like
+// ~/.cache/container-image-listing on linux
+func DefaultDiskCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to find user cache directory: %w", err)
+	}
+	return filepath.Join(dir, "container-image-listing"), nil
+}
+
+// diskCacheEntry is the content of a cache file
+type diskCacheEntry struct {
+	StoredAt time.Time `json:"stored_at"`
+	// ExpiresAt is zero for values kept forever
+	ExpiresAt time.Time       `json:"expires_at,omitempty"`
+	Value     json.RawMessage `json:"value"`
+}
+
+const (
+	// diskCacheEntriesDir is the directory of Dir that holds the cache files, so Evict never removes anything else
+	diskCacheEntriesDir = "entries"
+	diskCacheSuffix     = ".json"
+	diskCacheLockSuffix = ".lock"
+	diskCacheTempPrefix = ".tmp-"
+	// diskCacheEvictLock is the lock file taken while removing files past MaxSize
+	diskCacheEvictLock = ".evict" + diskCacheLockSuffix
+)
+
+func (d *DiskCache) maxSize() int64 {
+	if d.MaxSize == 0 {
+		return 100 << 20
+	}
+	return d.MaxSize
+}
+
+func (d *DiskCache) lockTimeout() time.Duration {
+	if d.LockTimeout == 0 {
+		return 10 * time.Second
+	}
+	return d.LockTimeout
+}
+
+func (d *DiskCache) currentTime() time.Time {
+	if d.now != nil {
+		return d.now()
+	}
+	return time.Now()
+}
+
+// path returns the file for key.  Every part of the key is escaped, so keys cannot point outside of Dir.
+func (d *DiskCache) path(key string) string {
+	parts := strings.Split(key, "/")
+	escaped := make([]string, 0, len(parts)+2)
+	escaped = append(escaped, d.Dir, diskCacheEntriesDir)
+	for _, part := range parts {
+		part = url.QueryEscape(part)
+		if part == "" || strings.HasPrefix(part, ".") {
+			// Keeps out "", "." and "..", and names that could collide with lock and temporary files
+			part = "%" + part
+		}
+		escaped = append(escaped, part)
+	}
+	return filepath.Join(escaped...) + diskCacheSuffix
+}
+
+// errNoDiskCacheDir is returned by a DiskCache without a Dir, which would otherwise write to the working directory
+var errNoDiskCacheDir = errors.New("disk cache has no Dir")
+
+func (d *DiskCache) Get(_ context.Context, key string, value interface{}) (CacheStatus, error) {
+	if d.Dir == "" {
+		return CacheMiss, errNoDiskCacheDir
+	}
+	p := d.path(key)
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return CacheMiss, nil
+		}
+		return CacheMiss, fmt.Errorf("unable to read cache file %s: %w", p, err)
+	}
+	var entry diskCacheEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return CacheMiss, fmt.Errorf("unable to decode cache file %s: %w", p, err)
+	}
+	if err := json.Unmarshal(entry.Value, value); err != nil {
+		return CacheMiss, fmt.Errorf("unable to decode cached value in %s: %w", p, err)
+	}
+	now := d.currentTime()
+	// The modification time says when the file was last used, which is what MaxSize removes files by
+	_ = os.Chtimes(p, now, now)
+	if !entry.ExpiresAt.IsZero() && !now.Before(entry.ExpiresAt) {
+		return CacheStale, nil
+	}
+	return CacheFresh, nil
+}
+
+func (d *DiskCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	if d.Dir == "" {
+		return errNoDiskCacheDir
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("unable to encode cache value: %w", err)
+	}
+	entry := diskCacheEntry{
+		StoredAt: d.currentTime(),
+		Value:    encoded,
+	}
+	if ttl > 0 {
+		entry.ExpiresAt = entry.StoredAt.Add(ttl)
+	}
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("unable to encode cache entry: %w", err)
+	}
+
+	p := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("unable to create cache directory: %w", err)
+	}
+	unlock, err := d.lock(ctx, p+diskCacheLockSuffix)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(p, content)
+	unlock()
+	if err != nil {
+		return err
+	}
+	d.maybeEvict(ctx, int64(len(content)))
+	return nil
+}
+
+// writeFileAtomic writes content to a temporary file next to p, then renames it over p, so readers see either the old
+// or the new content
+func writeFileAtomic(p string, content []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(p), diskCacheTempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary cache file: %w", err)
+	}
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("unable to write temporary cache file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("unable to close temporary cache file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("unable to move cache file into place: %w", err)
+	}
+	return nil
+}
+
+// lock creates lockPath, which only one process can do at a time, and returns a function that removes it
+func (d *DiskCache) lock(ctx context.Context, lockPath string) (func(), error) {
+	deadline := time.Now().Add(d.lockTimeout())
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			_ = f.Close()
+			return func() { _ = os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("unable to create lock file %s: %w", lockPath, err)
+		}
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > d.lockTimeout() {
+			// Whoever took the lock died without removing it
+			_ = os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock file %s", lockPath)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// maybeEvict checks MaxSize the first time this process writes, and again every time it has written another tenth of
+// MaxSize, so every Set does not have to walk Dir
+func (d *DiskCache) maybeEvict(ctx context.Context, written int64) {
+	d.mu.Lock()
+	d.writtenSinceEvict += written
+	if d.evictChecked && d.writtenSinceEvict < d.maxSize()/10 {
+		d.mu.Unlock()
+		return
+	}
+	d.evictChecked = true
+	d.writtenSinceEvict = 0
+	d.mu.Unlock()
+	// Failing to evict only means the cache uses more space for a while, so the error is not worth failing Set over
+	_ = d.Evict(ctx)
+}
+
+// Evict removes the least recently used cache files until they use at most MaxSize bytes.  Set calls it on its
+// own every so often.  If another process is already evicting, Evict leaves it to them.
+func (d *DiskCache) Evict(_ context.Context) error {
+	if d.Dir == "" {
+		return errNoDiskCacheDir
+	}
+	lockPath := filepath.Join(d.Dir, diskCacheEvictLock)
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > d.lockTimeout() {
+				_ = os.Remove(lockPath)
+			}
+			return nil
+		}
+		return fmt.Errorf("unable to create lock file %s: %w", lockPath, err)
+	}
+	_ = f.Close()
+	defer func() { _ = os.Remove(lockPath) }()
+
+	type cacheFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cacheFile
+	var total int64
+	err = filepath.Walk(filepath.Join(d.Dir, diskCacheEntriesDir), func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// Removed by another process while we were walking
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), diskCacheSuffix) || strings.HasPrefix(info.Name(), diskCacheTempPrefix) {
+			return nil
+		}
+		files = append(files, cacheFile{path: p, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list cache files: %w", err)
+	}
+	if total <= d.maxSize() {
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, f := range files {
+		if total <= d.maxSize() {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("unable to remove cache file %s: %w", f.path, err)
+		}
+		total -= f.size
+	}
+	return nil
+}
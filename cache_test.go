@@ -0,0 +1,159 @@
+package containerimagelisting
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskCache(t *testing.T) {
+	now := time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC)
+	d := DiskCache{
+		Dir: t.TempDir(),
+		now: func() time.Time { return now },
+	}
+	ctx := context.Background()
+	var tags []string
+	status, err := d.Get(ctx, "tags/ghcr.io/cresta/app", &tags)
+	require.NoError(t, err)
+	require.Equal(t, CacheMiss, status)
+
+	require.NoError(t, d.Set(ctx, "tags/ghcr.io/cresta/app", []string{"a", "b"}, time.Minute))
+	require.NoError(t, d.Set(ctx, "manifests/ghcr.io/cresta/app/sha256:abc", "forever", 0))
+	require.FileExists(t, filepath.Join(d.Dir, "entries", "tags", "ghcr.io", "cresta", "app.json"))
+
+	status, err = d.Get(ctx, "tags/ghcr.io/cresta/app", &tags)
+	require.NoError(t, err)
+	require.Equal(t, CacheFresh, status)
+	require.Equal(t, []string{"a", "b"}, tags)
+
+	// Expired values are still returned, so callers can decide what to do with them
+	now = now.Add(2 * time.Minute)
+	tags = nil
+	status, err = d.Get(ctx, "tags/ghcr.io/cresta/app", &tags)
+	require.NoError(t, err)
+	require.Equal(t, CacheStale, status)
+	require.Equal(t, []string{"a", "b"}, tags)
+
+	now = now.Add(24 * 365 * time.Hour)
+	var manifest string
+	status, err = d.Get(ctx, "manifests/ghcr.io/cresta/app/sha256:abc", &manifest)
+	require.NoError(t, err)
+	require.Equal(t, CacheFresh, status)
+	require.Equal(t, "forever", manifest)
+
+	// Files that are not cache entries are errors, not values
+	p := d.path("corrupt")
+	require.NoError(t, ioutil.WriteFile(p, []byte("not json"), 0o644))
+	status, err = d.Get(ctx, "corrupt", &manifest)
+	require.Error(t, err)
+	require.Equal(t, CacheMiss, status)
+}
+
+func TestDiskCache_Path(t *testing.T) {
+	d := DiskCache{Dir: "/cache"}
+	require.Equal(t, filepath.Join("/cache", "entries", "v2", "manifests", "localhost%3A5000", "app", "sha256%3Aabc.json"), d.path("v2/manifests/localhost:5000/app/sha256:abc"))
+	for _, key := range []string{"../../etc/passwd", "a//b", "./.lock", "a/.evict"} {
+		p := d.path(key)
+		require.True(t, strings.HasPrefix(p, "/cache/entries/"), p)
+		for _, part := range strings.Split(strings.TrimPrefix(p, "/cache/entries/"), "/") {
+			require.NotEqual(t, "..", part)
+			require.False(t, strings.HasPrefix(part, "."), p)
+		}
+	}
+}
+
+func TestDiskCache_Evict(t *testing.T) {
+	d := DiskCache{Dir: t.TempDir()}
+	ctx := context.Background()
+	value := strings.Repeat("x", 200)
+	start := time.Now().Add(-time.Hour)
+	for i, key := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, d.Set(ctx, key, value, 0))
+		modTime := start.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, os.Chtimes(d.path(key), modTime, modTime))
+	}
+	// Reading a makes it the most recently used
+	var got string
+	_, err := d.Get(ctx, "a", &got)
+	require.NoError(t, err)
+	require.NoError(t, d.Set(ctx, "e", value, 0))
+
+	// Files the cache did not write are never evicted
+	foreign := filepath.Join(d.Dir, "package.json")
+	require.NoError(t, ioutil.WriteFile(foreign, []byte(value), 0o644))
+	require.NoError(t, os.Chtimes(foreign, start, start))
+
+	// Each file is a little under 300 bytes, so three of the five fit
+	d.MaxSize = 1000
+	require.NoError(t, d.Evict(ctx))
+	require.FileExists(t, foreign)
+	for _, key := range []string{"b", "c"} {
+		require.NoFileExists(t, d.path(key))
+	}
+	for _, key := range []string{"a", "d", "e"} {
+		require.FileExists(t, d.path(key))
+	}
+}
+
+func TestDiskCache_Lock(t *testing.T) {
+	d := DiskCache{
+		Dir:         t.TempDir(),
+		LockTimeout: 50 * time.Millisecond,
+	}
+	ctx := context.Background()
+	lockPath := d.path("held") + diskCacheLockSuffix
+	require.NoError(t, os.MkdirAll(filepath.Dir(lockPath), 0o755))
+	unlock, err := d.lock(ctx, lockPath)
+	require.NoError(t, err)
+
+	// A lock held past LockTimeout is assumed to be left over from a process that died
+	done := make(chan error)
+	go func() {
+		done <- d.Set(ctx, "held", "value", 0)
+	}()
+	require.NoError(t, <-done)
+	unlock()
+
+	// Writers of the same key wait for each other
+	d.LockTimeout = 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, d.Set(ctx, "shared", "value", 0))
+		}()
+	}
+	wg.Wait()
+	var got string
+	status, err := d.Get(ctx, "shared", &got)
+	require.NoError(t, err)
+	require.Equal(t, CacheFresh, status)
+	require.Equal(t, "value", got)
+	files, err := ioutil.ReadDir(filepath.Join(d.Dir, "entries"))
+	require.NoError(t, err)
+	for _, f := range files {
+		require.False(t, strings.HasPrefix(f.Name(), diskCacheTempPrefix), f.Name())
+		require.False(t, strings.HasSuffix(f.Name(), diskCacheLockSuffix), f.Name())
+	}
+}
+
+func TestDiskCache_NoDir(t *testing.T) {
+	// Without a Dir, files would end up in the working directory
+	d := DiskCache{}
+	ctx := context.Background()
+	require.Error(t, d.Set(ctx, "key", "value", 0))
+	var got string
+	status, err := d.Get(ctx, "key", &got)
+	require.Error(t, err)
+	require.Equal(t, CacheMiss, status)
+	require.Error(t, d.Evict(ctx))
+}
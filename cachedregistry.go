@@ -0,0 +1,220 @@
+package containerimagelisting
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// CachedRegistry is a Registry that remembers what another Registry's ListTags returned, so many callers can share
+// one registry, like a RegistryFinder, without asking the network every time
+type CachedRegistry struct {
+	Registry Registry
+	// TTL is how long tags are reused before they are listed again.  Defaults to 1 minute.
+	TTL time.Duration
+	// NegativeTTL is how long ErrRepositoryNotFound is remembered.  Defaults to 10 seconds.  Negative values turn off
+	// negative caching.
+	NegativeTTL time.Duration
+	// MaxStale is how long past TTL tags are still returned when listing them again fails, for example because the
+	// registry is down or rate limiting us.  Defaults to 1 hour.  Negative values turn off stale results.
+	MaxStale time.Duration
+	// MaxEntries is how many repositories are remembered.  The least recently used ones are forgotten first.  Defaults
+	// to 1000.  Negative values remember every repository.
+	MaxEntries int
+	// now returns the current time.  Replaced by tests.
+	now func() time.Time
+
+	mu       sync.Mutex
+	lru      *list.List
+	entries  map[string]*list.Element
+	inflight map[string]*cachedCall
+}
+
+var _ Registry = &CachedRegistry{}
+
+// cachedEntry is the answer for one repository
+type cachedEntry struct {
+	repository string
+	tags       []Tag
+	// err is only set for negative caching
+	err      error
+	storedAt time.Time
+}
+
+// cachedCall is a ListTags in flight, which concurrent callers for the same repository wait on
+type cachedCall struct {
+	done chan struct{}
+	tags []Tag
+	err  error
+}
+
+func (c *CachedRegistry) ttl() time.Duration {
+	if c.TTL == 0 {
+		return time.Minute
+	}
+	return c.TTL
+}
+
+func (c *CachedRegistry) negativeTTL() time.Duration {
+	if c.NegativeTTL == 0 {
+		return 10 * time.Second
+	}
+	return c.NegativeTTL
+}
+
+func (c *CachedRegistry) maxStale() time.Duration {
+	if c.MaxStale == 0 {
+		return time.Hour
+	}
+	return c.MaxStale
+}
+
+func (c *CachedRegistry) maxEntries() int {
+	if c.MaxEntries == 0 {
+		return 1000
+	}
+	return c.MaxEntries
+}
+
+func (c *CachedRegistry) currentTime() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// ListTags returns the remembered tags for repository if they are fresh, and lists them again otherwise.  Concurrent
+// calls for the same repository share one ListTags of the wrapped Registry.
+func (c *CachedRegistry) ListTags(ctx context.Context, repository string) ([]Tag, error) {
+	for {
+		c.mu.Lock()
+		entry := c.lookup(repository)
+		if entry != nil && c.fresh(entry) {
+			c.mu.Unlock()
+			return copyTags(entry.tags), entry.err
+		}
+		call, leader := c.startCall(repository)
+		c.mu.Unlock()
+
+		if leader {
+			c.finishCall(ctx, repository, call)
+		} else {
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if call.err != nil && errors.Is(call.err, context.Canceled) && ctx.Err() == nil && !leader {
+			// The caller we were waiting on gave up, but we did not, so try again
+			continue
+		}
+		if call.err != nil && ctx.Err() == nil && !c.negativelyCached(call.err) && entry != nil && entry.err == nil && c.usableWhenStale(entry) {
+			return copyTags(entry.tags), nil
+		}
+		return copyTags(call.tags), call.err
+	}
+}
+
+// Invalidate forgets what was remembered for repository
+func (c *CachedRegistry) Invalidate(repository string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, exists := c.entries[repository]; exists {
+		c.lru.Remove(elem)
+		delete(c.entries, repository)
+	}
+}
+
+// lookup returns the entry for repository and marks it as recently used.  c.mu must be held.
+func (c *CachedRegistry) lookup(repository string) *cachedEntry {
+	elem, exists := c.entries[repository]
+	if !exists {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cachedEntry)
+}
+
+func (c *CachedRegistry) fresh(entry *cachedEntry) bool {
+	age := c.currentTime().Sub(entry.storedAt)
+	if entry.err != nil {
+		return age < c.negativeTTL()
+	}
+	return age < c.ttl()
+}
+
+func (c *CachedRegistry) usableWhenStale(entry *cachedEntry) bool {
+	if c.maxStale() < 0 {
+		return false
+	}
+	return c.currentTime().Sub(entry.storedAt) < c.ttl()+c.maxStale()
+}
+
+func (c *CachedRegistry) negativelyCached(err error) bool {
+	return c.negativeTTL() > 0 && errors.Is(err, ErrRepositoryNotFound)
+}
+
+// startCall returns the call in flight for repository, or starts one.  leader is true if the caller must make the
+// call.  c.mu must be held.
+func (c *CachedRegistry) startCall(repository string) (call *cachedCall, leader bool) {
+	if call, exists := c.inflight[repository]; exists {
+		return call, false
+	}
+	if c.inflight == nil {
+		c.inflight = make(map[string]*cachedCall)
+	}
+	call = &cachedCall{done: make(chan struct{})}
+	c.inflight[repository] = call
+	return call, true
+}
+
+// finishCall lists the tags for call and remembers them
+func (c *CachedRegistry) finishCall(ctx context.Context, repository string, call *cachedCall) {
+	call.tags, call.err = c.Registry.ListTags(ctx, repository)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.inflight, repository)
+	close(call.done)
+	switch {
+	case call.err == nil:
+		c.store(&cachedEntry{repository: repository, tags: copyTags(call.tags), storedAt: c.currentTime()})
+	case c.negativelyCached(call.err):
+		c.store(&cachedEntry{repository: repository, err: call.err, storedAt: c.currentTime()})
+	}
+}
+
+// store remembers entry, forgetting the least recently used entries past MaxEntries, if it is positive.  c.mu must be
+// held.
+func (c *CachedRegistry) store(entry *cachedEntry) {
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+		c.lru = list.New()
+	}
+	if elem, exists := c.entries[entry.repository]; exists {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.repository] = c.lru.PushFront(entry)
+	for c.maxEntries() > 0 && c.lru.Len() > c.maxEntries() {
+		oldest := c.lru.Back()
+		if oldest == nil {
+			return
+		}
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedEntry).repository)
+	}
+}
+
+// copyTags copies tags, so callers that sort them, like SortTagsByCreated, do not change what is cached
+func copyTags(tags []Tag) []Tag {
+	if tags == nil {
+		return nil
+	}
+	ret := make([]Tag, len(tags))
+	copy(ret, tags)
+	return ret
+}
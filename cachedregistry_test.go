@@ -0,0 +1,168 @@
+package containerimagelisting
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingRegistry answers ListTags with answer, counting calls per repository
+type countingRegistry struct {
+	mu      sync.Mutex
+	calls   map[string]int
+	answer  func(repository string) ([]Tag, error)
+	release chan struct{}
+}
+
+func (r *countingRegistry) ListTags(ctx context.Context, repository string) ([]Tag, error) {
+	r.mu.Lock()
+	if r.calls == nil {
+		r.calls = make(map[string]int)
+	}
+	r.calls[repository]++
+	r.mu.Unlock()
+	if r.release != nil {
+		select {
+		case <-r.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return r.answer(repository)
+}
+
+func (r *countingRegistry) callsFor(repository string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls[repository]
+}
+
+func TestCachedRegistry(t *testing.T) {
+	now := time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC)
+	var answerErr error
+	inner := &countingRegistry{answer: func(repository string) ([]Tag, error) {
+		if repository == "missing" {
+			return nil, &StatusError{StatusCode: 404}
+		}
+		if answerErr != nil {
+			return nil, answerErr
+		}
+		return []Tag{&staticTag{tag: "b"}, &staticTag{tag: "a"}}, nil
+	}}
+	c := CachedRegistry{
+		Registry:    inner,
+		TTL:         time.Minute,
+		NegativeTTL: 10 * time.Second,
+		MaxStale:    time.Hour,
+		now:         func() time.Time { return now },
+	}
+	ctx := context.Background()
+
+	tags, err := c.ListTags(ctx, "repo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "a"}, tagNames(tags))
+	// Callers sorting the result do not change the cache
+	tags[0], tags[1] = tags[1], tags[0]
+	tags, err = c.ListTags(ctx, "repo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "a"}, tagNames(tags))
+	require.Equal(t, 1, inner.callsFor("repo"))
+
+	// Expired entries are listed again
+	now = now.Add(2 * time.Minute)
+	_, err = c.ListTags(ctx, "repo")
+	require.NoError(t, err)
+	require.Equal(t, 2, inner.callsFor("repo"))
+
+	// Errors return stale tags until MaxStale runs out
+	answerErr = &StatusError{StatusCode: 503}
+	now = now.Add(2 * time.Minute)
+	tags, err = c.ListTags(ctx, "repo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "a"}, tagNames(tags))
+	require.Equal(t, 3, inner.callsFor("repo"))
+	now = now.Add(2 * time.Hour)
+	_, err = c.ListTags(ctx, "repo")
+	require.ErrorIs(t, err, ErrRegistryUnavailable)
+
+	// Not found is remembered for NegativeTTL
+	for i := 0; i < 2; i++ {
+		_, err = c.ListTags(ctx, "missing")
+		require.ErrorIs(t, err, ErrRepositoryNotFound)
+	}
+	require.Equal(t, 1, inner.callsFor("missing"))
+	now = now.Add(11 * time.Second)
+	_, err = c.ListTags(ctx, "missing")
+	require.ErrorIs(t, err, ErrRepositoryNotFound)
+	require.Equal(t, 2, inner.callsFor("missing"))
+
+	c.Invalidate("missing")
+	_, err = c.ListTags(ctx, "missing")
+	require.ErrorIs(t, err, ErrRepositoryNotFound)
+	require.Equal(t, 3, inner.callsFor("missing"))
+}
+
+func TestCachedRegistry_LRU(t *testing.T) {
+	inner := &countingRegistry{answer: func(repository string) ([]Tag, error) {
+		return []Tag{&staticTag{tag: repository}}, nil
+	}}
+	c := CachedRegistry{
+		Registry:   inner,
+		MaxEntries: 2,
+	}
+	ctx := context.Background()
+	for _, repo := range []string{"a", "b", "a", "c", "a", "b"} {
+		_, err := c.ListTags(ctx, repo)
+		require.NoError(t, err)
+	}
+	// b was the least recently used when c was added
+	require.Equal(t, 1, inner.callsFor("a"))
+	require.Equal(t, 2, inner.callsFor("b"))
+	require.Equal(t, 1, inner.callsFor("c"))
+
+	// Negative MaxEntries remembers every repository
+	unbounded := CachedRegistry{
+		Registry:   inner,
+		MaxEntries: -1,
+	}
+	for _, repo := range []string{"d", "e", "f", "d", "e", "f"} {
+		_, err := unbounded.ListTags(ctx, repo)
+		require.NoError(t, err)
+	}
+	for _, repo := range []string{"d", "e", "f"} {
+		require.Equal(t, 1, inner.callsFor(repo))
+	}
+}
+
+func TestCachedRegistry_SingleFlight(t *testing.T) {
+	inner := &countingRegistry{
+		answer: func(repository string) ([]Tag, error) {
+			return []Tag{&staticTag{tag: "a"}}, nil
+		},
+		release: make(chan struct{}),
+	}
+	c := CachedRegistry{Registry: inner}
+	var wg sync.WaitGroup
+	results := make([][]Tag, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tags, err := c.ListTags(context.Background(), "repo")
+			require.NoError(t, err)
+			results[i] = tags
+		}(i)
+	}
+	// Wait for the first call to start, give the rest a moment to pile up behind it, then let it finish
+	require.Eventually(t, func() bool { return inner.callsFor("repo") == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(inner.release)
+	wg.Wait()
+	require.Equal(t, 1, inner.callsFor("repo"))
+	for _, tags := range results {
+		require.Equal(t, []string{"a"}, tagNames(tags))
+	}
+}
@@ -0,0 +1,84 @@
+package containerimagelisting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// RepositoryLister is implemented by registries that can list the repositories they store
+type RepositoryLister interface {
+	// ListRepositories returns the repositories inside namespace.  For example, namespace "cresta" could return
+	// "cresta/foo" and "cresta/bar".  An empty namespace lists every repository the registry will show.
+	ListRepositories(ctx context.Context, namespace string) ([]string, error)
+}
+
+// inNamespace returns true if repository is inside namespace, or namespace is empty
+func inNamespace(repository string, namespace string) bool {
+	return namespace == "" || strings.HasPrefix(repository, strings.TrimSuffix(namespace, "/")+"/")
+}
+
+var _ RepositoryLister = &DockerV2{}
+
+// ListRepositories uses the catalog API.  Docker Hub, and some other public registries, do not support it.
+func (c *DockerV2) ListRepositories(ctx context.Context, namespace string) ([]string, error) {
+	// Documented at https://docs.docker.com/registry/spec/api/#catalog
+	repos, err := c.listPaginated(ctx, fmt.Sprintf("%s/v2/_catalog", c.BaseURL), catalogAuthKey, func(body []byte) ([]string, error) {
+		var cr struct {
+			Repositories []string `json:"repositories"`
+		}
+		if err := json.Unmarshal(body, &cr); err != nil {
+			return nil, fmt.Errorf("unable to decode response body: %w", err)
+		}
+		return cr.Repositories, nil
+	})
+	if err != nil {
+		// Registries without the catalog API answer 404, which says nothing about any repository
+		return nil, notFoundAs(err, ErrUnsupported)
+	}
+	ret := make([]string, 0, len(repos))
+	for _, r := range repos {
+		if inNamespace(r, namespace) {
+			ret = append(ret, r)
+		}
+	}
+	return ret, nil
+}
+
+var _ RepositoryLister = &Quay{}
+
+// ListRepositories lists the repositories of a quay namespace, which is required
+func (q *Quay) ListRepositories(ctx context.Context, namespace string) ([]string, error) {
+	if namespace == "" {
+		return nil, fmt.Errorf("quay can only list repositories inside a namespace: %w", ErrUnsupported)
+	}
+	var ret []string
+	nextPage := ""
+	for {
+		query := make(url.Values)
+		query.Add("namespace", namespace)
+		if nextPage != "" {
+			query.Add("next_page", nextPage)
+		}
+		// Documented on https://docs.quay.io/api/swagger/#!/repository/listRepos
+		var lrr struct {
+			Repositories []struct {
+				Namespace string `json:"namespace"`
+				Name      string `json:"name"`
+			} `json:"repositories"`
+			NextPage string `json:"next_page"`
+		}
+		if err := q.getJSON(ctx, "/api/v1/repository", query, &lrr); err != nil {
+			return nil, err
+		}
+		for _, r := range lrr.Repositories {
+			ret = append(ret, r.Namespace+"/"+r.Name)
+		}
+		if lrr.NextPage == "" {
+			return ret, nil
+		}
+		nextPage = lrr.NextPage
+	}
+}
@@ -0,0 +1,150 @@
+package containerimagelisting
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerV2_ListRepositories(t *testing.T) {
+	d := DockerV2{
+		BaseURL: "http://example.com",
+		Client: &http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				require.Equal(t, "/v2/_catalog", r.URL.Path)
+				header := make(http.Header)
+				body := `{"repositories": ["cresta/c", "other/d"]}`
+				if r.URL.Query().Get("last") == "" {
+					header.Set("Link", `</v2/_catalog?last=cresta%2Fb&n=2>; rel="next"`)
+					body = `{"repositories": ["cresta/a", "cresta/b"]}`
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     header,
+					Body:       ioutil.NopCloser(strings.NewReader(body)),
+				}, nil
+			}),
+		},
+	}
+	ctx := context.Background()
+	repos, err := d.ListRepositories(ctx, "cresta")
+	require.NoError(t, err)
+	require.Equal(t, []string{"cresta/a", "cresta/b", "cresta/c"}, repos)
+
+	finder := RegistryFinder{
+		Registries: []RegistryWithFinder{
+			{
+				Registry:          &d,
+				RepositoryLocator: &MultiURLHostMatcher{ValidDomains: []string{"example.com"}},
+			},
+		},
+	}
+	repos, err = finder.ListRepositories(ctx, "example.com/other")
+	require.NoError(t, err)
+	require.Equal(t, []string{"example.com/other/d"}, repos)
+}
+
+func TestRegistryFinder_ListRepositoriesWholeRegistry(t *testing.T) {
+	listed := make(map[string][]string)
+	lister := func(name string, repos ...string) *DockerV2 {
+		return &DockerV2{
+			BaseURL: "http://" + name,
+			Client: &http.Client{
+				Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					listed[name] = append(listed[name], r.URL.Path)
+					body, _ := json.Marshal(map[string][]string{"repositories": repos})
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       ioutil.NopCloser(strings.NewReader(string(body))),
+					}, nil
+				}),
+			},
+		}
+	}
+	ecrHost := "123456789012.dkr.ecr.us-east-1.amazonaws.com"
+	finder := RegistryFinder{
+		Registries: []RegistryWithFinder{
+			{
+				Registry:          lister("hub"),
+				RepositoryLocator: &DockerHubLocator{},
+			},
+			{
+				Registry:          lister("ecr", "a", "team/b"),
+				RepositoryLocator: &MultiURLHostMatcher{ValidDomains: []string{ecrHost}},
+			},
+			{
+				Registry:          lister("local", "c"),
+				RepositoryLocator: &MultiURLHostMatcher{ValidDomains: []string{"localhost:5000"}},
+			},
+		},
+	}
+	ctx := context.Background()
+	for _, namespace := range []string{ecrHost, ecrHost + "/"} {
+		repos, err := finder.ListRepositories(ctx, namespace)
+		require.NoError(t, err)
+		require.Equal(t, []string{ecrHost + "/a", ecrHost + "/team/b"}, repos)
+	}
+	repos, err := finder.ListRepositories(ctx, "localhost:5000/")
+	require.NoError(t, err)
+	require.Equal(t, []string{"localhost:5000/c"}, repos)
+	require.Empty(t, listed["hub"])
+}
+
+func TestRegistryFinder_ListRepositoriesDockerHub(t *testing.T) {
+	finder := RegistryFinder{
+		Registries: []RegistryWithFinder{
+			{
+				Registry: &DockerV2{
+					BaseURL: "http://hub",
+					Client: &http.Client{
+						Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+							return &http.Response{
+								StatusCode: http.StatusOK,
+								Body:       ioutil.NopCloser(strings.NewReader(`{"repositories": ["cresta/a", "library/cresta", "library/redis"]}`)),
+							}, nil
+						}),
+					},
+				},
+				RepositoryLocator: &DockerHubLocator{},
+			},
+		},
+	}
+	ctx := context.Background()
+	// Namespaces are not official images, so cresta is not looked for in library/
+	repos, err := finder.ListRepositories(ctx, "cresta")
+	require.NoError(t, err)
+	require.Equal(t, []string{"cresta/a"}, repos)
+	repos, err = finder.ListRepositories(ctx, "docker.io/cresta")
+	require.NoError(t, err)
+	require.Equal(t, []string{"docker.io/cresta/a"}, repos)
+	repos, err = finder.ListRepositories(ctx, "library")
+	require.NoError(t, err)
+	require.Equal(t, []string{"library/cresta", "library/redis"}, repos)
+}
+
+func TestQuay_ListRepositories(t *testing.T) {
+	q := Quay{
+		Client: &http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				require.Equal(t, "/api/v1/repository", r.URL.Path)
+				require.Equal(t, "cresta", r.URL.Query().Get("namespace"))
+				body := `{"repositories": [{"namespace": "cresta", "name": "b"}]}`
+				if r.URL.Query().Get("next_page") == "" {
+					body = `{"repositories": [{"namespace": "cresta", "name": "a"}], "next_page": "token"}`
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       ioutil.NopCloser(strings.NewReader(body)),
+				}, nil
+			}),
+		},
+	}
+	repos, err := q.ListRepositories(context.Background(), "cresta")
+	require.NoError(t, err)
+	require.Equal(t, []string{"cresta/a", "cresta/b"}, repos)
+}
@@ -2,6 +2,9 @@ package containerimagelisting
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"time"
 )
 
 // Tag is the tag of a docker image.  Some repositories, like quay for example, may extend this interface with extra
@@ -27,3 +30,62 @@ type Registry interface {
 	// prioritize the tags most recently created.
 	ListTags(ctx context.Context, repository string) ([]Tag, error)
 }
+
+// TagDetails is the extra information registries may know about a tag.  Anything a registry does not know is left as
+// the zero value.
+type TagDetails struct {
+	// Digest of the manifest the tag points to, like sha256:abc...
+	Digest string
+	// Created is when the tag was created or pushed
+	Created time.Time
+	// Size of the image in bytes
+	Size int64
+	// MediaType of the manifest the tag points to
+	MediaType string
+	// MultiArch is true if the tag points to a manifest list or OCI index
+	MultiArch bool
+	// OS and Architecture the image was built for
+	OS           string
+	Architecture string
+	// Labels set on the image when it was built
+	Labels map[string]string
+}
+
+// DetailedTag is a Tag that can describe itself in more detail.  Some registries need extra requests to find the
+// details, so Details takes a context and may fail.
+type DetailedTag interface {
+	Tag
+	Details(ctx context.Context) (*TagDetails, error)
+}
+
+// DetailsForTag returns the details of tag, or empty details if the tag does not implement DetailedTag
+func DetailsForTag(ctx context.Context, tag Tag) (*TagDetails, error) {
+	if dt, ok := tag.(DetailedTag); ok {
+		return dt.Details(ctx)
+	}
+	return &TagDetails{}, nil
+}
+
+// SortTagsByCreated sorts tags with the most recently created first.  Tags without a known creation time go last.
+func SortTagsByCreated(ctx context.Context, tags []Tag) error {
+	// Tags are paired with their times instead of used as map keys, since not every Tag is hashable
+	type createdTag struct {
+		tag     Tag
+		created time.Time
+	}
+	sorted := make([]createdTag, 0, len(tags))
+	for _, t := range tags {
+		details, err := DetailsForTag(ctx, t)
+		if err != nil {
+			return fmt.Errorf("unable to fetch details for tag %s: %w", t.Tag(), err)
+		}
+		sorted = append(sorted, createdTag{tag: t, created: details.Created})
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].created.After(sorted[j].created)
+	})
+	for i := range sorted {
+		tags[i] = sorted[i].tag
+	}
+	return nil
+}
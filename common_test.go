@@ -0,0 +1,39 @@
+package containerimagelisting
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortTagsByCreated(t *testing.T) {
+	tags := []Tag{
+		&QuayTag{Name: "old", StartTs: 100},
+		&staticTag{tag: "unknown"},
+		&QuayTag{Name: "new", StartTs: 300},
+		&QuayTag{Name: "middle", StartTs: 200},
+	}
+	require.NoError(t, SortTagsByCreated(context.Background(), tags))
+	var names []string
+	for _, t := range tags {
+		names = append(names, t.Tag())
+	}
+	require.Equal(t, []string{"new", "middle", "old", "unknown"}, names)
+
+	// Tags do not need to be hashable
+	tags = []Tag{unhashableTag{name: "a"}, unhashableTag{name: "b"}}
+	require.NoError(t, SortTagsByCreated(context.Background(), tags))
+	require.Equal(t, "a", tags[0].Tag())
+}
+
+// unhashableTag is a Tag that cannot be a map key
+type unhashableTag struct {
+	name string
+	// labels is never set.  Slices make the struct unhashable.
+	labels []string
+}
+
+func (t unhashableTag) Tag() string {
+	return t.name
+}
@@ -0,0 +1,259 @@
+package containerimagelisting
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Credentials are what a registry needs to know who we are.  Usually only Username and Password are set.
+type Credentials struct {
+	Username string
+	Password string
+	// IdentityToken is an OAuth2 refresh token, used instead of Username and Password
+	IdentityToken string
+	// RegistryToken is a bearer token sent directly to the registry
+	RegistryToken string
+}
+
+// CredentialProvider finds credentials for a registry host, like ghcr.io.  It should return nil credentials, and no
+// error, if it has none for the host.
+type CredentialProvider interface {
+	Credentials(ctx context.Context, host string) (*Credentials, error)
+}
+
+// CredentialProviderFunc is a function wrapper for CredentialProvider
+type CredentialProviderFunc func(ctx context.Context, host string) (*Credentials, error)
+
+func (c CredentialProviderFunc) Credentials(ctx context.Context, host string) (*Credentials, error) {
+	return c(ctx, host)
+}
+
+var _ CredentialProvider = CredentialProviderFunc(nil)
+
+// dockerHubHosts are the hosts that all mean docker hub
+var dockerHubHosts = []string{"docker.io", "index.docker.io", "registry-1.docker.io"}
+
+// dockerHubServerURL is the name docker login stores docker hub credentials under
+const dockerHubServerURL = "https://index.docker.io/v1/"
+
+func isDockerHubHost(host string) bool {
+	for _, h := range dockerHubHosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// DockerConfig is the content of a docker config.json file, as written by docker login
+type DockerConfig struct {
+	Auths       map[string]DockerConfigAuth `json:"auths"`
+	CredsStore  string                      `json:"credsStore,omitempty"`
+	CredHelpers map[string]string           `json:"credHelpers,omitempty"`
+	// runHelper runs a credential helper.  Defaults to running docker-credential-<helper> get.
+	runHelper func(ctx context.Context, helper string, serverURL string) ([]byte, error)
+}
+
+// DockerConfigAuth is a single entry of the auths section of a docker config
+type DockerConfigAuth struct {
+	// Auth is base64 of username:password
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+	RegistryToken string `json:"registrytoken,omitempty"`
+}
+
+var _ CredentialProvider = &DockerConfig{}
+
+// DefaultDockerConfigPath returns where docker keeps its config: $DOCKER_CONFIG/config.json or ~/.docker/config.json
+func DefaultDockerConfigPath() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to find home directory: %w", err)
+	}
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// LoadDefaultDockerConfig loads the docker config at DefaultDockerConfigPath.  A missing file is an empty config.
+func LoadDefaultDockerConfig() (*DockerConfig, error) {
+	path, err := DefaultDockerConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := LoadDockerConfig(path)
+	if os.IsNotExist(err) {
+		return &DockerConfig{}, nil
+	}
+	return cfg, err
+}
+
+// LoadDockerConfig loads a docker config file
+func LoadDockerConfig(path string) (*DockerConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDockerConfig(content)
+}
+
+// ParseDockerConfig parses the content of a docker config file
+func ParseDockerConfig(content []byte) (*DockerConfig, error) {
+	var ret DockerConfig
+	if err := json.Unmarshal(content, &ret); err != nil {
+		return nil, fmt.Errorf("docker config does not appear to be JSON: %w", err)
+	}
+	return &ret, nil
+}
+
+// Credentials returns the credentials for host, looking in credHelpers, then credsStore, then auths, like docker does
+func (d *DockerConfig) Credentials(ctx context.Context, host string) (*Credentials, error) {
+	serverURL := host
+	if isDockerHubHost(host) {
+		serverURL = dockerHubServerURL
+	}
+	if helper := d.credHelperFor(host, serverURL); helper != "" {
+		return d.helperCredentials(ctx, helper, serverURL)
+	}
+	if d.CredsStore != "" {
+		creds, err := d.helperCredentials(ctx, d.CredsStore, serverURL)
+		if err != nil || creds != nil {
+			return creds, err
+		}
+	}
+	keys := make([]string, 0, len(d.Auths))
+	for key := range d.Auths {
+		keys = append(keys, key)
+	}
+	if key, ok := configKeyFor(keys, host, serverURL); ok {
+		return d.Auths[key].credentials()
+	}
+	return nil, nil
+}
+
+func (d *DockerConfig) credHelperFor(host string, serverURL string) string {
+	keys := make([]string, 0, len(d.CredHelpers))
+	for key := range d.CredHelpers {
+		keys = append(keys, key)
+	}
+	if key, ok := configKeyFor(keys, host, serverURL); ok {
+		return d.CredHelpers[key]
+	}
+	return ""
+}
+
+// configKeyFor picks which of the keys of a docker config section is for host.  A config can name one registry more
+// than once, like https://index.docker.io/v1/ and docker.io, so keys named exactly serverURL or host win, and other
+// keys for the same registry are picked in sorted order, so the same config always gives the same credentials.
+func configKeyFor(keys []string, host string, serverURL string) (string, bool) {
+	sort.Strings(keys)
+	for _, exact := range []string{serverURL, host} {
+		for _, key := range keys {
+			if key == exact {
+				return key, true
+			}
+		}
+	}
+	for _, key := range keys {
+		if registryHostOf(key) == registryHostOf(host) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// registryHostOf turns the different ways docker configs name a registry, like https://index.docker.io/v1/, into a
+// host that can be compared.  All docker hub hosts become docker.io.
+func registryHostOf(key string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	host = strings.SplitN(host, "/", 2)[0]
+	if isDockerHubHost(host) {
+		return "docker.io"
+	}
+	return host
+}
+
+func (a DockerConfigAuth) credentials() (*Credentials, error) {
+	ret := Credentials{
+		Username:      a.Username,
+		Password:      a.Password,
+		IdentityToken: a.IdentityToken,
+		RegistryToken: a.RegistryToken,
+	}
+	if a.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode auth as base64: %w", err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("auth is not in the form username:password")
+		}
+		ret.Username = parts[0]
+		ret.Password = parts[1]
+	}
+	return &ret, nil
+}
+
+// helperCredentials asks a credential helper for credentials.  It returns nil if the helper does not know the server.
+func (d *DockerConfig) helperCredentials(ctx context.Context, helper string, serverURL string) (*Credentials, error) {
+	// Protocol documented at https://github.com/docker/docker-credential-helpers
+	run := d.runHelper
+	if run == nil {
+		run = runCredentialHelper
+	}
+	out, err := run(ctx, helper, serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get credentials for %s from docker-credential-%s: %w", serverURL, helper, err)
+	}
+	if out == nil {
+		return nil, nil
+	}
+	var resp struct {
+		ServerURL string
+		Username  string
+		Secret    string
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, fmt.Errorf("unable to decode output of docker-credential-%s: %w", helper, err)
+	}
+	if resp.Username == "<token>" {
+		// Helpers store identity tokens under this special username
+		return &Credentials{IdentityToken: resp.Secret}, nil
+	}
+	return &Credentials{
+		Username: resp.Username,
+		Password: resp.Secret,
+	}, nil
+}
+
+// credentialsNotFound is the message helpers print when they do not know a server
+const credentialsNotFound = "credentials not found in native keychain"
+
+// runCredentialHelper runs docker-credential-<helper> get.  It returns nil output if the helper does not know serverURL.
+func runCredentialHelper(ctx context.Context, helper string, serverURL string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stdout.String()+stderr.String(), credentialsNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()+stdout.String()))
+	}
+	return stdout.Bytes(), nil
+}
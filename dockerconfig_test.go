@@ -0,0 +1,178 @@
+package containerimagelisting
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testDockerConfig = `{
+	"auths": {
+		"https://index.docker.io/v1/": {"auth": "am9objpkb2U="},
+		"ghcr.io": {"identitytoken": "refresh_1"},
+		"registry.example.com": {"username": "robot", "password": "secret"}
+	},
+	"credsStore": "desktop",
+	"credHelpers": {
+		"123456789012.dkr.ecr.us-west-2.amazonaws.com": "ecr-login"
+	}
+}`
+
+func TestDockerConfig_Credentials(t *testing.T) {
+	cfg, err := ParseDockerConfig([]byte(testDockerConfig))
+	require.NoError(t, err)
+	var helperCalls []string
+	cfg.runHelper = func(ctx context.Context, helper string, serverURL string) ([]byte, error) {
+		helperCalls = append(helperCalls, helper+" "+serverURL)
+		switch {
+		case helper == "ecr-login":
+			return []byte(`{"ServerURL": "` + serverURL + `", "Username": "AWS", "Secret": "ecr_password"}`), nil
+		case serverURL == "quay.io":
+			return []byte(`{"ServerURL": "quay.io", "Username": "<token>", "Secret": "quay_identity"}`), nil
+		}
+		return nil, nil
+	}
+	ctx := context.Background()
+
+	t.Run("base64_auth_for_docker_hub_aliases", func(t *testing.T) {
+		for _, host := range []string{"docker.io", "index.docker.io", "registry-1.docker.io"} {
+			creds, err := cfg.Credentials(ctx, host)
+			require.NoError(t, err)
+			require.Equal(t, &Credentials{Username: "john", Password: "doe"}, creds)
+		}
+	})
+	t.Run("identity_token", func(t *testing.T) {
+		creds, err := cfg.Credentials(ctx, "ghcr.io")
+		require.NoError(t, err)
+		require.Equal(t, &Credentials{IdentityToken: "refresh_1"}, creds)
+	})
+	t.Run("cred_helper", func(t *testing.T) {
+		creds, err := cfg.Credentials(ctx, "123456789012.dkr.ecr.us-west-2.amazonaws.com")
+		require.NoError(t, err)
+		require.Equal(t, &Credentials{Username: "AWS", Password: "ecr_password"}, creds)
+	})
+	t.Run("creds_store", func(t *testing.T) {
+		creds, err := cfg.Credentials(ctx, "quay.io")
+		require.NoError(t, err)
+		require.Equal(t, &Credentials{IdentityToken: "quay_identity"}, creds)
+	})
+	t.Run("unknown_host", func(t *testing.T) {
+		creds, err := cfg.Credentials(ctx, "unknown.example.com")
+		require.NoError(t, err)
+		require.Nil(t, creds)
+	})
+	require.Contains(t, helperCalls, "desktop https://index.docker.io/v1/")
+	require.Contains(t, helperCalls, "ecr-login 123456789012.dkr.ecr.us-west-2.amazonaws.com")
+}
+
+func TestDockerConfig_CredentialsDuplicateKeys(t *testing.T) {
+	cfg, err := ParseDockerConfig([]byte(`{
+	"auths": {
+		"docker.io": {"username": "short", "password": "short_password"},
+		"https://index.docker.io/v1/": {"username": "login", "password": "login_password"},
+		"registry-1.docker.io": {"username": "registry", "password": "registry_password"},
+		"https://ghcr.io": {"username": "https", "password": "https_password"},
+		"ghcr.io/": {"username": "slash", "password": "slash_password"}
+	},
+	"credHelpers": {
+		"https://123456789012.dkr.ecr.us-west-2.amazonaws.com": "other",
+		"123456789012.dkr.ecr.us-west-2.amazonaws.com": "ecr-login"
+	}
+}`))
+	require.NoError(t, err)
+	ctx := context.Background()
+	// Go randomizes map order, so a lookup that depends on it would not give the same answer every time
+	for i := 0; i < 20; i++ {
+		creds, err := cfg.Credentials(ctx, "registry-1.docker.io")
+		require.NoError(t, err)
+		require.Equal(t, &Credentials{Username: "login", Password: "login_password"}, creds)
+
+		creds, err = cfg.Credentials(ctx, "ghcr.io")
+		require.NoError(t, err)
+		require.Equal(t, &Credentials{Username: "slash", Password: "slash_password"}, creds)
+
+		require.Equal(t, "ecr-login", cfg.credHelperFor("123456789012.dkr.ecr.us-west-2.amazonaws.com", "123456789012.dkr.ecr.us-west-2.amazonaws.com"))
+	}
+}
+
+func TestLoadDockerConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	cfg, err := LoadDefaultDockerConfig()
+	require.NoError(t, err)
+	require.Empty(t, cfg.Auths)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(testDockerConfig), 0600))
+	cfg, err = LoadDefaultDockerConfig()
+	require.NoError(t, err)
+	require.Len(t, cfg.Auths, 3)
+	require.Equal(t, "desktop", cfg.CredsStore)
+}
+
+func TestScopeReauther_CredentialProvider(t *testing.T) {
+	var lookedUp []string
+	d := DockerV2{
+		BaseURL: "http://registry.example.com",
+		Client: &http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				if r.URL.Path == "/token" {
+					u, p, ok := r.BasicAuth()
+					require.True(t, ok)
+					require.Equal(t, "robot", u)
+					require.Equal(t, "secret", p)
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       ioutil.NopCloser(strings.NewReader(`{"token": "abc"}`)),
+					}, nil
+				}
+				if r.Header.Get("Authorization") != "Bearer abc" {
+					header := make(http.Header)
+					header.Set("Www-Authenticate", `Bearer realm="http://registry.example.com/token",service="registry.example.com",scope="repository:test_repo:pull"`)
+					return &http.Response{
+						StatusCode: http.StatusUnauthorized,
+						Header:     header,
+						// http.Transport fills this in, and ScopeReauther uses it to look up credentials
+						Request: r,
+						Body:    ioutil.NopCloser(strings.NewReader("")),
+					}, nil
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       ioutil.NopCloser(strings.NewReader(`{"name": "test_repo", "tags": ["a"]}`)),
+				}, nil
+			}),
+		},
+		ReAuth: &ScopeReauther{
+			Credentials: CredentialProviderFunc(func(ctx context.Context, host string) (*Credentials, error) {
+				lookedUp = append(lookedUp, host)
+				return &Credentials{Username: "robot", Password: "secret"}, nil
+			}),
+		},
+	}
+	tags, err := d.ListTags(context.Background(), "test_repo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, tagNames(tags))
+	require.Equal(t, []string{"registry.example.com"}, lookedUp)
+}
+
+func TestQuay_CredentialProvider(t *testing.T) {
+	q := Quay{
+		Credentials: CredentialProviderFunc(func(ctx context.Context, host string) (*Credentials, error) {
+			require.Equal(t, "quay.io", host)
+			return &Credentials{Username: "$oauthtoken", Password: "oauth_token"}, nil
+		}),
+	}
+	token, err := q.token(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "oauth_token", token)
+
+	q.Token = "explicit"
+	token, err = q.token(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "explicit", token)
+}
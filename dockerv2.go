@@ -7,6 +7,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
 )
 
 // DockerV2 is a registry API for registries that implement the Docker v2 registry API.
@@ -16,6 +21,27 @@ type DockerV2 struct {
 	ReAuth            *ScopeReauther
 	RequestWrapper    RequestWrapper
 	MaxReAuthAttempts int
+	// PageSize is sent as the "n" parameter of paginated requests.  When zero, the registry picks its own page size.
+	PageSize int
+	// MaxPages is the most pages a single listing will follow before returning an error.  Defaults to 1000.
+	MaxPages int
+	// EnrichTags makes ListTags fetch the manifest and config of every tag up front, so the details of each tag, like
+	// when it was created, are known without more requests.  Tags whose details fail to fetch are still listed, and
+	// fetch them again when Details is called.
+	EnrichTags bool
+	// EnrichConcurrency is how many tags are enriched at the same time.  Defaults to 4, as do zero and negative values.
+	EnrichConcurrency int
+	// Retry retries requests that failed with transport errors, 5xx responses or rate limits.  Nil means no retries.
+	Retry *RetryPolicy
+	// Cache remembers tag lists for CacheTTL, and manifests fetched by digest forever, which lets short lived processes
+	// like CI jobs reuse what earlier runs listed.  Nil means nothing is cached.
+	Cache Cache
+	// CacheTTL is how long tag lists in Cache are reused before they are listed again.  Defaults to 5 minutes.  After
+	// that, pages are only downloaded again if the registry says their ETag or Last-Modified changed.
+	CacheTTL time.Duration
+	authMu   sync.Mutex
+	// authWrappers remembers the auth that worked for each repository, so later requests can send it up front
+	authWrappers map[string]RequestWrapper
 }
 
 func (c *DockerV2) maxReAuthAttempts() int {
@@ -25,81 +51,396 @@ func (c *DockerV2) maxReAuthAttempts() int {
 	return c.MaxReAuthAttempts
 }
 
+func (c *DockerV2) maxPages() int {
+	if c.MaxPages == 0 {
+		return 1000
+	}
+	return c.MaxPages
+}
+
+func (c *DockerV2) cacheTTL() time.Duration {
+	if c.CacheTTL == 0 {
+		return 5 * time.Minute
+	}
+	return c.CacheTTL
+}
+
 var _ Registry = &DockerV2{}
 
 // ListTags - Return tags for name in no particular order.
 // IE, name="library/redis"
 func (c *DockerV2) ListTags(ctx context.Context, repository string) ([]Tag, error) {
-	return c.listTagsWithAuthWrapper(ctx, repository, nil, 1)
+	tags, err := c.listTagNames(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+	v2Tags := make([]*DockerV2Tag, 0, len(tags))
+	for _, t := range tags {
+		v2Tags = append(v2Tags, &DockerV2Tag{
+			Name:       t,
+			repository: repository,
+			registry:   c,
+		})
+	}
+	if c.EnrichTags {
+		if err := c.enrichTags(ctx, v2Tags); err != nil {
+			return nil, fmt.Errorf("unable to enrich tags: %w", err)
+		}
+	}
+	var ret []Tag
+	for _, t := range v2Tags {
+		ret = append(ret, t)
+	}
+	return ret, nil
 }
 
-func (c *DockerV2) listTagsWithAuthWrapper(ctx context.Context, repository string, authWrapper RequestWrapper, attemptNumber int) ([]Tag, error) {
-	// Documented at https://docs.docker.com/registry/spec/api/#listing-image-tags
+// cachedTagList is what DockerV2 stores in its Cache for a repository's tags
+type cachedTagList struct {
+	Pages []listPage `json:"pages"`
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/v2/%s/tags/list", c.BaseURL, repository), nil)
+// listTagNames returns the tags of repository from Cache if they are fresh, and lists them otherwise.  Pages of a stale
+// listing are only downloaded again if the registry says they changed.
+func (c *DockerV2) listTagNames(ctx context.Context, repository string) ([]string, error) {
+	key := cacheKey("v2", "tags", hostOfURL(c.BaseURL), repository)
+	var cached cachedTagList
+	if c.Cache != nil {
+		status, err := c.Cache.Get(ctx, key, &cached)
+		if err != nil {
+			cached = cachedTagList{}
+		}
+		if err == nil && status == CacheFresh {
+			return pageItems(cached.Pages), nil
+		}
+	}
+	// Documented at https://docs.docker.com/registry/spec/api/#listing-image-tags
+	pages, err := c.listPages(ctx, fmt.Sprintf("%s/v2/%s/tags/list", c.BaseURL, repository), repositoryAuthKey(repository), cached.Pages, func(body []byte) ([]string, error) {
+		// Defined at https://docs.docker.com/registry/spec/api/#listing-image-tags
+		var tlr struct {
+			Name string   `json:"name"`
+			Tags []string `json:"tags"`
+		}
+		if err := json.Unmarshal(body, &tlr); err != nil {
+			return nil, fmt.Errorf("unable to decode response body: %w", err)
+		}
+		return tlr.Tags, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("uanble to build http request: %w", err)
+		return nil, err
+	}
+	if c.Cache != nil {
+		_ = c.Cache.Set(ctx, key, cachedTagList{Pages: pages}, c.cacheTTL())
 	}
+	return pageItems(pages), nil
+}
 
-	req.Header.Add("Accept", "application/json")
-	if authWrapper != nil {
-		if err := authWrapper.Wrap(req); err != nil {
-			return nil, fmt.Errorf("unable to wrap auth with request wrapper: %w", err)
-		}
+// DockerV2Tag is a tag listed by DockerV2.  Its details are fetched from the tag's manifest and image config the first
+// time they are asked for, then remembered.
+type DockerV2Tag struct {
+	Name       string
+	repository string
+	registry   *DockerV2
+	mu         sync.Mutex
+	details    *TagDetails
+}
+
+func (t *DockerV2Tag) Tag() string {
+	return t.Name
+}
+
+// Details fetches the manifest and image config the tag points to.  Size is only known for single platform images.  For
+// multi platform images, Created, OS, Architecture and Labels come from the linux/amd64 image if there is one.
+func (t *DockerV2Tag) Details(ctx context.Context) (*TagDetails, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.details != nil {
+		return t.details, nil
 	}
-	if c.RequestWrapper != nil {
-		if err := c.RequestWrapper.Wrap(req); err != nil {
-			return nil, fmt.Errorf("unable to wrap auth with default wrapper: %w", err)
+	m, err := t.registry.GetManifest(ctx, t.repository, t.Name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch manifest for %s:%s: %w", t.repository, t.Name, err)
+	}
+	details := TagDetails{
+		Digest:    m.Digest,
+		MediaType: m.MediaType,
+		MultiArch: m.IsIndex(),
+	}
+	if !details.MultiArch && m.Manifest.Config != nil {
+		details.Size = m.Manifest.Config.Size
+		for _, l := range m.Manifest.Layers {
+			details.Size += l.Size
 		}
 	}
+	cfg, err := t.registry.imageConfigForManifest(ctx, t.repository, m)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch image config for %s:%s: %w", t.repository, t.Name, err)
+	}
+	details.Created = cfg.Created
+	details.OS = cfg.OS
+	details.Architecture = cfg.Architecture
+	details.Labels = cfg.Labels
+	t.details = &details
+	return t.details, nil
+}
+
+var _ DetailedTag = &DockerV2Tag{}
 
-	// Perform request
-	resp, err := c.Client.Do(req)
+// listPaginated fetches every page of a paginated endpoint, like tags/list, starting at firstURL.  decode should return
+// the items of a single page.  Pages are followed using the Link header and, for registries that do not send one, the
+// n/last query parameters.
+func (c *DockerV2) listPaginated(ctx context.Context, firstURL string, authKey string, decode func(body []byte) ([]string, error)) ([]string, error) {
+	pages, err := c.listPages(ctx, firstURL, authKey, nil, decode)
 	if err != nil {
-		return nil, fmt.Errorf("uanble to issue HTTP request to list tags: %w", err)
+		return nil, err
 	}
+	return pageItems(pages), nil
+}
+
+// listPage is one page of a paginated listing, as stored in Cache
+type listPage struct {
+	URL   string   `json:"url"`
+	Items []string `json:"items"`
+	// Next is the URL of the next page, or empty for the last page
+	Next       string          `json:"next,omitempty"`
+	Validators cacheValidators `json:"validators"`
+}
 
-	var body bytes.Buffer
-	if _, err := io.Copy(&body, resp.Body); err != nil {
-		return nil, fmt.Errorf("unable to copy from response body: %w", err)
+// pageItems returns the items of every page, leaving out items repeated on later pages
+func pageItems(pages []listPage) []string {
+	var ret []string
+	seen := make(map[string]struct{})
+	for _, page := range pages {
+		for _, item := range page.Items {
+			if _, exists := seen[item]; exists {
+				continue
+			}
+			seen[item] = struct{}{}
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
+
+// listPages is listPaginated that returns the pages themselves.  Pages in previous are requested with their
+// validators, and reused when the registry says they did not change.
+func (c *DockerV2) listPages(ctx context.Context, firstURL string, authKey string, previous []listPage, decode func(body []byte) ([]string, error)) ([]listPage, error) {
+	// Pagination is documented at https://docs.docker.com/registry/spec/api/#pagination
+	pageURL, err := url.Parse(firstURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse URL %s: %w", firstURL, err)
 	}
-	if err := resp.Body.Close(); err != nil {
-		return nil, fmt.Errorf("unable to close response body: %w", err)
+	if c.PageSize > 0 {
+		query := pageURL.Query()
+		query.Set("n", strconv.Itoa(c.PageSize))
+		pageURL.RawQuery = query.Encode()
+	}
+	previousByURL := make(map[string]listPage, len(previous))
+	for _, page := range previous {
+		previousByURL[page.URL] = page
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		// Try to reauth if we have one
-		if c.ReAuth != nil {
-			if attemptNumber > c.maxReAuthAttempts() {
-				return nil, fmt.Errorf("past maximum reauth attempts of %d", c.maxReAuthAttempts())
+	var ret []listPage
+	seen := make(map[string]struct{})
+	for page := 0; pageURL != nil; page++ {
+		if page >= c.maxPages() {
+			return nil, fmt.Errorf("past maximum page count of %d", c.maxPages())
+		}
+		header := make(http.Header)
+		header.Set("Accept", "application/json")
+		prev, hasPrev := previousByURL[pageURL.String()]
+		if hasPrev {
+			prev.Validators.setHeaders(header)
+		}
+		resp, body, err := c.do(ctx, http.MethodGet, pageURL.String(), header, authKey)
+		if err != nil {
+			return nil, err
+		}
+
+		current := listPage{
+			URL:        pageURL.String(),
+			Validators: validatorsOf(resp.Header),
+		}
+		var next *url.URL
+		switch {
+		case resp.StatusCode == http.StatusNotModified && hasPrev:
+			// 304 responses have no body, and may not repeat the Link header either
+			current.Items = prev.Items
+			current.Validators = notModifiedValidators(resp.Header, prev.Validators)
+			if prev.Next != "" {
+				next, err = url.Parse(prev.Next)
+				if err != nil {
+					return nil, fmt.Errorf("unable to parse URL %s: %w", prev.Next, err)
+				}
 			}
-			reauthFunc, err := c.ReAuth.CheckForReauth(ctx, resp, c.Client)
+		case resp.StatusCode != http.StatusOK:
+			return nil, newDockerV2Error(resp, body)
+		default:
+			current.Items, err = decode(body)
 			if err != nil {
-				return nil, fmt.Errorf("unable to check for reauth: %w", err)
+				return nil, err
 			}
-			if reauthFunc != nil {
-				// TODO: Cache this function for this repository
-				return c.listTagsWithAuthWrapper(ctx, repository, reauthFunc, attemptNumber+1)
+			next, err = c.nextPageURL(pageURL, resp.Header, current.Items)
+			if err != nil {
+				return nil, err
 			}
 		}
-		return nil, fmt.Errorf("invalid status code %d with response %s", resp.StatusCode, resp.Status)
+		if next != nil {
+			current.Next = next.String()
+		}
+		ret = append(ret, current)
+
+		newItems := 0
+		for _, item := range current.Items {
+			if _, exists := seen[item]; !exists {
+				seen[item] = struct{}{}
+				newItems++
+			}
+		}
+		if newItems == 0 {
+			// A page with nothing new means the registry is ignoring our pagination parameters
+			break
+		}
+		pageURL = next
 	}
+	return ret, nil
+}
+
+var linkNextRegex = regexp.MustCompile(`<([^>]*)>\s*;\s*rel="?next"?`)
 
-	// Defined at https://docs.docker.com/registry/spec/api/#listing-image-tags
-	type tagListResp struct {
-		Name string   `json:"name"`
-		Tags []string `json:"tags"`
+// nextPageURL returns the URL of the page after current, or nil if current is the last page
+func (c *DockerV2) nextPageURL(current *url.URL, header http.Header, items []string) (*url.URL, error) {
+	// Link header looks like `</v2/_catalog?n=2&last=b>; rel="next"`
+	for _, link := range header.Values("Link") {
+		if m := linkNextRegex.FindStringSubmatch(link); m != nil {
+			next, err := current.Parse(m[1])
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse Link header %s: %w", link, err)
+			}
+			return next, nil
+		}
 	}
+	// Without a Link header, a full page may still mean there are more items after the last one we saw
+	if c.PageSize <= 0 || len(items) < c.PageSize {
+		return nil, nil
+	}
+	next := *current
+	query := next.Query()
+	query.Set("n", strconv.Itoa(c.PageSize))
+	query.Set("last", items[len(items)-1])
+	next.RawQuery = query.Encode()
+	return &next, nil
+}
+
+// repositoryAuthKey is the key of authWrappers for requests about a repository
+func repositoryAuthKey(repository string) string {
+	return "repository:" + repository
+}
 
-	var tlr tagListResp
+// catalogAuthKey is the key of authWrappers for catalog requests
+const catalogAuthKey = "registry:catalog"
 
-	if err := json.NewDecoder(&body).Decode(&tlr); err != nil {
-		return nil, fmt.Errorf("unable to decode response body: %w", err)
+// cachedAuth returns the auth that last worked for authKey, refreshing it if it is about to expire
+func (c *DockerV2) cachedAuth(ctx context.Context, authKey string) (RequestWrapper, error) {
+	c.authMu.Lock()
+	authWrapper := c.authWrappers[authKey]
+	c.authMu.Unlock()
+	if authWrapper == nil || c.ReAuth == nil {
+		return authWrapper, nil
 	}
-	var ret []Tag
-	for _, t := range tlr.Tags {
-		ret = append(ret, &staticTag{tag: t})
+	refreshed, err := c.ReAuth.refresh(ctx, authWrapper, c.Client)
+	if err != nil {
+		return nil, fmt.Errorf("unable to refresh auth: %w", err)
 	}
+	return refreshed, nil
+}
 
-	return ret, nil
+func (c *DockerV2) storeAuth(authKey string, authWrapper RequestWrapper) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	if authWrapper == nil {
+		delete(c.authWrappers, authKey)
+		return
+	}
+	if c.authWrappers == nil {
+		c.authWrappers = make(map[string]RequestWrapper)
+	}
+	if _, exists := c.authWrappers[authKey]; !exists {
+		c.removeExpiredAuth(time.Now())
+	}
+	c.authWrappers[authKey] = authWrapper
+}
+
+// removeExpiredAuth forgets auth that expired, so repositories that are never asked for again do not pile up in
+// authWrappers.  c.authMu must be held.
+func (c *DockerV2) removeExpiredAuth(now time.Time) {
+	for key, authWrapper := range c.authWrappers {
+		if token, ok := authWrapper.(*scopedToken); ok && token.expired(now) {
+			delete(c.authWrappers, key)
+		}
+	}
+}
+
+// do executes a single request against the registry.  Auth that worked before for authKey is sent up front.  If the
+// registry rejects the request and ReAuth is set, do will ask ReAuth for credentials and try again.  The response body
+// is read and closed before returning.
+func (c *DockerV2) do(ctx context.Context, method string, u string, header http.Header, authKey string) (*http.Response, []byte, error) {
+	authWrapper, err := c.cachedAuth(ctx, authKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	for attemptNumber := 1; ; attemptNumber++ {
+		req, err := http.NewRequestWithContext(ctx, method, u, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to build http request: %w", err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if authWrapper != nil {
+			if err := authWrapper.Wrap(req); err != nil {
+				return nil, nil, fmt.Errorf("unable to wrap auth with request wrapper: %w", err)
+			}
+		}
+		if c.RequestWrapper != nil {
+			if err := c.RequestWrapper.Wrap(req); err != nil {
+				return nil, nil, fmt.Errorf("unable to wrap auth with default wrapper: %w", err)
+			}
+		}
+
+		// Perform request
+		resp, err := c.Retry.do(c.Client, req)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to issue HTTP request to %s: %w", u, err)
+		}
+
+		var body bytes.Buffer
+		if _, err := io.Copy(&body, resp.Body); err != nil {
+			return nil, nil, fmt.Errorf("unable to copy from response body: %w", err)
+		}
+		if err := resp.Body.Close(); err != nil {
+			return nil, nil, fmt.Errorf("unable to close response body: %w", err)
+		}
+
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotModified || c.ReAuth == nil {
+			c.storeAuth(authKey, authWrapper)
+			return resp, body.Bytes(), nil
+		}
+		if authWrapper != nil && resp.StatusCode == http.StatusUnauthorized {
+			// The registry no longer accepts this token, so make sure we do not get it back from the cache
+			c.ReAuth.forget(authWrapper)
+			c.storeAuth(authKey, nil)
+		}
+		// Try to reauth if we have one
+		if attemptNumber > c.maxReAuthAttempts() {
+			return nil, nil, fmt.Errorf("past maximum reauth attempts of %d: %w", c.maxReAuthAttempts(), newDockerV2Error(resp, body.Bytes()))
+		}
+		reauthFunc, err := c.ReAuth.CheckForReauth(ctx, resp, c.Client)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to check for reauth: %w", err)
+		}
+		if reauthFunc == nil {
+			return resp, body.Bytes(), nil
+		}
+		authWrapper = reauthFunc
+	}
 }
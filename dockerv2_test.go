@@ -2,10 +2,16 @@ package containerimagelisting
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -28,5 +34,354 @@ func TestDockerV2_ListTags(t *testing.T) {
 	ctx := context.Background()
 	tags, err := d.ListTags(ctx, "test_repo")
 	require.NoError(t, err)
-	require.Equal(t, []Tag{&staticTag{tag: "test_name"}}, tags)
+	require.Equal(t, []string{"test_name"}, tagNames(tags))
+}
+
+func tagNames(tags []Tag) []string {
+	ret := make([]string, 0, len(tags))
+	for _, t := range tags {
+		ret = append(ret, t.Tag())
+	}
+	return ret
+}
+
+func TestDockerV2_ListTagsPagination(t *testing.T) {
+	pages := map[string]string{
+		"":  `{"name": "test_repo", "tags": ["a", "b"]}`,
+		"b": `{"name": "test_repo", "tags": ["c", "d"]}`,
+		"d": `{"name": "test_repo", "tags": ["e"]}`,
+	}
+	d := DockerV2{
+		BaseURL:  "http://example.com",
+		PageSize: 2,
+		Client: &http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				require.Equal(t, "/v2/test_repo/tags/list", r.URL.Path)
+				require.Equal(t, "2", r.URL.Query().Get("n"))
+				last := r.URL.Query().Get("last")
+				header := make(http.Header)
+				if last == "" {
+					// First page uses a Link header, later pages fall back to n/last
+					header.Set("Link", `</v2/test_repo/tags/list?n=2&last=b>; rel="next"`)
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     header,
+					Body:       ioutil.NopCloser(strings.NewReader(pages[last])),
+				}, nil
+			}),
+		},
+	}
+	ctx := context.Background()
+	tags, err := d.ListTags(ctx, "test_repo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c", "d", "e"}, tagNames(tags))
+
+	d.MaxPages = 2
+	_, err = d.ListTags(ctx, "test_repo")
+	require.Error(t, err)
+}
+
+func TestDockerV2_ListTagsError(t *testing.T) {
+	d := DockerV2{
+		BaseURL: "http://example.com",
+		Client: &http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusNotFound,
+					Status:     "404 Not Found",
+					Body: ioutil.NopCloser(strings.NewReader(`{"errors": [
+{"code": "NAME_UNKNOWN", "message": "repository name not known to registry", "detail": {"name": "test_repo"}}
+]}`)),
+				}, nil
+			}),
+		},
+	}
+	_, err := d.ListTags(context.Background(), "test_repo")
+	require.True(t, errors.Is(err, ErrRepositoryNotFound))
+	var v2Err *DockerV2Error
+	require.True(t, errors.As(err, &v2Err))
+	require.Equal(t, []string{"NAME_UNKNOWN"}, v2Err.Codes())
+	require.Equal(t, http.StatusNotFound, v2Err.StatusCode)
+	require.Equal(t, "repository name not known to registry", v2Err.Errors[0].Message)
+	var statusErr *StatusError
+	require.True(t, errors.As(err, &statusErr))
+}
+
+// fakeDockerV2Server serves tag lists, manifests and blobs from memory
+type fakeDockerV2Server struct {
+	tags      []string
+	manifests map[string]string
+	blobs     map[string]string
+	mu        sync.Mutex
+	requests  int
+}
+
+func (f *fakeDockerV2Server) addBlob(content string) string {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(content)))
+	f.blobs[digest] = content
+	return digest
+}
+
+func (f *fakeDockerV2Server) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	header := make(http.Header)
+	respond := func(body string) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     header,
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+		}, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v2/test_repo/"), "/", 2)
+	switch parts[0] {
+	case "tags":
+		b, _ := json.Marshal(map[string]interface{}{"name": "test_repo", "tags": f.tags})
+		return respond(string(b))
+	case "manifests":
+		if m, exists := f.manifests[parts[1]]; exists {
+			var parsed Manifest
+			_ = json.Unmarshal([]byte(m), &parsed)
+			header.Set("Content-Type", parsed.MediaType)
+			header.Set("Docker-Content-Digest", fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(m))))
+			return respond(m)
+		}
+	case "blobs":
+		if b, exists := f.blobs[parts[1]]; exists {
+			return respond(b)
+		}
+	}
+	return &http.Response{
+		StatusCode: http.StatusNotFound,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}, nil
+}
+
+func newFakeDockerV2Server() *fakeDockerV2Server {
+	f := &fakeDockerV2Server{
+		tags:      []string{"v1", "v2"},
+		manifests: make(map[string]string),
+		blobs:     make(map[string]string),
+	}
+	amd64Config := f.addBlob(`{"created": "2021-08-01T00:00:00Z", "architecture": "amd64", "os": "linux", "config": {"Labels": {"team": "infra"}}}`)
+	arm64Config := f.addBlob(`{"created": "2021-08-02T00:00:00Z", "architecture": "arm64", "os": "linux", "variant": "v8"}`)
+	amd64Manifest := fmt.Sprintf(`{"schemaVersion": 2, "mediaType": "%s", "config": {"digest": "%s", "size": 5}, "layers": [{"digest": "sha256:l1", "size": 100}]}`, MediaTypeDockerManifest, amd64Config)
+	arm64Manifest := fmt.Sprintf(`{"schemaVersion": 2, "mediaType": "%s", "config": {"digest": "%s", "size": 6}, "layers": [{"digest": "sha256:l2", "size": 200}]}`, MediaTypeDockerManifest, arm64Config)
+	amd64Digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(amd64Manifest)))
+	arm64Digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(arm64Manifest)))
+	f.manifests[amd64Digest] = amd64Manifest
+	f.manifests[arm64Digest] = arm64Manifest
+	f.manifests["v1"] = amd64Manifest
+	f.manifests["v2"] = fmt.Sprintf(`{"schemaVersion": 2, "mediaType": "%s", "manifests": [
+{"digest": "%s", "size": 10, "platform": {"architecture": "arm64", "os": "linux", "variant": "v8"}},
+{"digest": "%s", "size": 10, "platform": {"architecture": "amd64", "os": "linux"}}
+]}`, MediaTypeDockerManifestList, arm64Digest, amd64Digest)
+	return f
+}
+
+func TestDockerV2Tag_Details(t *testing.T) {
+	server := newFakeDockerV2Server()
+	d := DockerV2{
+		BaseURL: "http://example.com",
+		Client:  &http.Client{Transport: server},
+	}
+	ctx := context.Background()
+	tags, err := d.ListTags(ctx, "test_repo")
+	require.NoError(t, err)
+	require.Len(t, tags, 2)
+	for i := 0; i < 2; i++ {
+		details, err := DetailsForTag(ctx, tags[0])
+		require.NoError(t, err)
+		require.Equal(t, "v1", tags[0].Tag())
+		require.Equal(t, int64(105), details.Size)
+		require.Equal(t, MediaTypeDockerManifest, details.MediaType)
+		require.Equal(t, time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC), details.Created)
+		require.Equal(t, map[string]string{"team": "infra"}, details.Labels)
+		require.False(t, details.MultiArch)
+	}
+	// One listing, one manifest and one config blob
+	require.Equal(t, 3, server.requests)
+
+	details, err := DetailsForTag(ctx, tags[1])
+	require.NoError(t, err)
+	require.True(t, details.MultiArch)
+	require.Equal(t, "amd64", details.Architecture)
+	require.Equal(t, time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC), details.Created)
+}
+
+func TestDockerV2_EnrichTags(t *testing.T) {
+	server := newFakeDockerV2Server()
+	d := DockerV2{
+		BaseURL:           "http://example.com",
+		Client:            &http.Client{Transport: server},
+		EnrichTags:        true,
+		EnrichConcurrency: 2,
+	}
+	ctx := context.Background()
+	tags, err := d.ListTags(ctx, "test_repo")
+	require.NoError(t, err)
+	requestsAfterList := server.requests
+	require.NoError(t, SortTagsByCreated(ctx, tags))
+	require.Equal(t, requestsAfterList, server.requests)
+
+	// A tag deleted between listing and enriching is still listed, and reports the error when asked for its details
+	server.tags = append(server.tags, "deleted")
+	tags, err = d.ListTags(ctx, "test_repo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1", "v2", "deleted"}, tagNames(tags))
+	requestsAfterList = server.requests
+	_, err = DetailsForTag(ctx, tags[0])
+	require.NoError(t, err)
+	require.Equal(t, requestsAfterList, server.requests)
+	_, err = DetailsForTag(ctx, tags[2])
+	require.True(t, errors.Is(err, ErrManifestNotFound))
+	require.Equal(t, requestsAfterList+1, server.requests)
+
+	// Negative concurrency is the default, not no workers at all
+	d.EnrichConcurrency = -1
+	tags, err = d.ListTags(ctx, "test_repo")
+	require.NoError(t, err)
+	require.Len(t, tags, 3)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	unenriched := make([]*DockerV2Tag, 0, len(tags))
+	for _, tag := range tags {
+		unenriched = append(unenriched, &DockerV2Tag{Name: tag.Tag(), repository: "test_repo", registry: &d})
+	}
+	require.ErrorIs(t, d.enrichTags(canceled, unenriched), context.Canceled)
+}
+
+func TestDockerV2_Cache(t *testing.T) {
+	server := newFakeDockerV2Server()
+	now := time.Now()
+	cache := &DiskCache{
+		Dir: t.TempDir(),
+		now: func() time.Time { return now },
+	}
+	// Each run of a CI job gets a new DockerV2, but shares the cache directory
+	newRegistry := func() *DockerV2 {
+		return &DockerV2{
+			BaseURL: "http://example.com",
+			Client:  &http.Client{Transport: server},
+			Cache:   cache,
+		}
+	}
+	ctx := context.Background()
+	tags, err := newRegistry().ListTags(ctx, "test_repo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1", "v2"}, tagNames(tags))
+	require.Equal(t, 1, server.requests)
+
+	server.tags = []string{"v1", "v2", "v3"}
+	tags, err = newRegistry().ListTags(ctx, "test_repo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1", "v2"}, tagNames(tags))
+	require.Equal(t, 1, server.requests)
+
+	// Manifests are cached by digest, but not by tag, since tags move
+	m, err := newRegistry().GetManifest(ctx, "test_repo", "v2")
+	require.NoError(t, err)
+	childDigest := m.Manifest.Manifests[0].Digest
+	_, err = newRegistry().GetManifest(ctx, "test_repo", "v2")
+	require.NoError(t, err)
+	require.Equal(t, 3, server.requests)
+	for i := 0; i < 2; i++ {
+		child, err := newRegistry().GetManifest(ctx, "test_repo", childDigest)
+		require.NoError(t, err)
+		require.Equal(t, childDigest, child.Digest)
+		require.Equal(t, MediaTypeDockerManifest, child.MediaType)
+		require.NotNil(t, child.Manifest.Config)
+	}
+	head, err := newRegistry().HeadManifest(ctx, "test_repo", childDigest)
+	require.NoError(t, err)
+	require.Equal(t, childDigest, head.Digest)
+	require.Equal(t, 4, server.requests)
+
+	// Once CacheTTL runs out, tags are listed again
+	now = now.Add(6 * time.Minute)
+	tags, err = newRegistry().ListTags(ctx, "test_repo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1", "v2", "v3"}, tagNames(tags))
+	require.Equal(t, 5, server.requests)
+}
+
+func TestDockerV2_ConditionalListTags(t *testing.T) {
+	pages := map[string]string{
+		"":  `{"name": "test_repo", "tags": ["a", "b"]}`,
+		"b": `{"name": "test_repo", "tags": ["c"]}`,
+	}
+	var notModified, downloaded int
+	d := func(cache Cache) *DockerV2 {
+		return &DockerV2{
+			BaseURL:  "http://example.com",
+			PageSize: 2,
+			Cache:    cache,
+			Client: &http.Client{
+				Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					last := r.URL.Query().Get("last")
+					body, exists := pages[last]
+					if !exists {
+						body = `{"name": "test_repo", "tags": []}`
+					}
+					etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(body)))
+					header := make(http.Header)
+					header.Set("ETag", etag)
+					if r.Header.Get("If-None-Match") == etag {
+						notModified++
+						return &http.Response{
+							StatusCode: http.StatusNotModified,
+							Header:     header,
+							Body:       ioutil.NopCloser(strings.NewReader("")),
+						}, nil
+					}
+					downloaded++
+					if last == "" {
+						header.Set("Link", `</v2/test_repo/tags/list?n=2&last=b>; rel="next"`)
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Header:     header,
+						Body:       ioutil.NopCloser(strings.NewReader(body)),
+					}, nil
+				}),
+			},
+		}
+	}
+	now := time.Now()
+	cache := &DiskCache{
+		Dir: t.TempDir(),
+		now: func() time.Time { return now },
+	}
+	ctx := context.Background()
+	tags, err := d(cache).ListTags(ctx, "test_repo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c"}, tagNames(tags))
+	require.Equal(t, 2, downloaded)
+
+	// Once the list is stale, unchanged pages are answered with 304, and the next page is still followed
+	now = now.Add(time.Hour)
+	tags, err = d(cache).ListTags(ctx, "test_repo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c"}, tagNames(tags))
+	require.Equal(t, 2, downloaded)
+	require.Equal(t, 2, notModified)
+
+	// Changed pages are downloaded again
+	now = now.Add(time.Hour)
+	pages["b"] = `{"name": "test_repo", "tags": ["c", "d"]}`
+	tags, err = d(cache).ListTags(ctx, "test_repo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c", "d"}, tagNames(tags))
+	// The changed page is full, so the page after it is asked for too
+	require.Equal(t, 4, downloaded)
+	require.Equal(t, 3, notModified)
+
+	// Without a cache, nothing is conditional
+	tags, err = d(nil).ListTags(ctx, "test_repo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c", "d"}, tagNames(tags))
+	require.Equal(t, 7, downloaded)
+	require.Equal(t, 3, notModified)
 }
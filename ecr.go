@@ -0,0 +1,197 @@
+package containerimagelisting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecr"
+)
+
+// ECRAPIClient should connect to AWS's ECR to describe repositories and images
+type ECRAPIClient interface {
+	// DescribeRepositoriesPagesWithContext should emulate aws-sdk-go's ECR.DescribeRepositoriesPagesWithContext function
+	DescribeRepositoriesPagesWithContext(ctx aws.Context, input *ecr.DescribeRepositoriesInput, fn func(*ecr.DescribeRepositoriesOutput, bool) bool, opts ...request.Option) error
+	// DescribeImagesPagesWithContext should emulate aws-sdk-go's ECR.DescribeImagesPagesWithContext function
+	DescribeImagesPagesWithContext(ctx aws.Context, input *ecr.DescribeImagesInput, fn func(*ecr.DescribeImagesOutput, bool) bool, opts ...request.Option) error
+}
+
+var _ ECRAPIClient = &ecr.ECR{}
+
+// ECR uses AWS's own ECR APIs, rather than the Docker v2 API
+type ECR struct {
+	ECR ECRAPIClient
+	// RegistryID is the AWS account ID that owns the registry.  When empty, AWS uses the account of the credentials.
+	RegistryID string
+}
+
+func (e *ECR) registryID() *string {
+	if e.RegistryID == "" {
+		return nil
+	}
+	return aws.String(e.RegistryID)
+}
+
+var _ Registry = &ECR{}
+
+// ECRTag implements the Tag type and also returns extra information ECR knows about the tagged image
+type ECRTag struct {
+	Name                   string
+	ImageDigest            string
+	ImagePushedAt          time.Time
+	ImageSizeInBytes       int64
+	ImageManifestMediaType string
+	ArtifactMediaType      string
+	// ScanStatus is the status of the last image scan, like COMPLETE.  It is empty if the image was never scanned.
+	ScanStatus string
+	// ScanFindings counts the findings of the last image scan by severity, like CRITICAL or HIGH
+	ScanFindings map[string]int64
+	// LastRecordedPullTime is zero if ECR has not recorded a pull of the image
+	LastRecordedPullTime time.Time
+}
+
+func (e *ECRTag) Tag() string {
+	return e.Name
+}
+
+// Details returns what ECR told us about the image when it was listed
+func (e *ECRTag) Details(_ context.Context) (*TagDetails, error) {
+	return &TagDetails{
+		Digest:    e.ImageDigest,
+		Created:   e.ImagePushedAt,
+		Size:      e.ImageSizeInBytes,
+		MediaType: e.ImageManifestMediaType,
+		MultiArch: e.ImageManifestMediaType == MediaTypeDockerManifestList || e.ImageManifestMediaType == MediaTypeOCIIndex,
+	}, nil
+}
+
+var _ DetailedTag = &ECRTag{}
+
+// ListTags returns every tag of an ECR repository, using DescribeImages so each tag knows when it was pushed
+func (e *ECR) ListTags(ctx context.Context, repository string) ([]Tag, error) {
+	var ret []Tag
+	input := ecr.DescribeImagesInput{
+		RegistryId:     e.registryID(),
+		RepositoryName: aws.String(repository),
+		Filter: &ecr.DescribeImagesFilter{
+			TagStatus: aws.String(ecr.TagStatusTagged),
+		},
+	}
+	err := e.ECR.DescribeImagesPagesWithContext(ctx, &input, func(output *ecr.DescribeImagesOutput, _ bool) bool {
+		for _, image := range output.ImageDetails {
+			for _, tag := range image.ImageTags {
+				ret = append(ret, newECRTag(aws.StringValue(tag), image))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe ECR images for %s: %w", repository, ecrError(err))
+	}
+	return ret, nil
+}
+
+func newECRTag(name string, image *ecr.ImageDetail) *ECRTag {
+	ret := ECRTag{
+		Name:                   name,
+		ImageDigest:            aws.StringValue(image.ImageDigest),
+		ImagePushedAt:          aws.TimeValue(image.ImagePushedAt),
+		ImageSizeInBytes:       aws.Int64Value(image.ImageSizeInBytes),
+		ImageManifestMediaType: aws.StringValue(image.ImageManifestMediaType),
+		ArtifactMediaType:      aws.StringValue(image.ArtifactMediaType),
+		LastRecordedPullTime:   aws.TimeValue(image.LastRecordedPullTime),
+	}
+	if image.ImageScanStatus != nil {
+		ret.ScanStatus = aws.StringValue(image.ImageScanStatus.Status)
+	}
+	if image.ImageScanFindingsSummary != nil {
+		ret.ScanFindings = aws.Int64ValueMap(image.ImageScanFindingsSummary.FindingSeverityCounts)
+	}
+	return &ret
+}
+
+// ecrAPIError is an error returned by the AWS SDK that also matches a sentinel error with errors.Is.  errors.As still
+// finds the awserr.Error.
+type ecrAPIError struct {
+	err      awserr.Error
+	sentinel error
+}
+
+func (e *ecrAPIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.sentinel, e.err.Error())
+}
+
+func (e *ecrAPIError) Unwrap() error {
+	return e.err
+}
+
+func (e *ecrAPIError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+// ecrError adds the matching sentinel error to errors returned by the AWS SDK
+func ecrError(err error) error {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return err
+	}
+	var sentinel error
+	switch awsErr.Code() {
+	case ecr.ErrCodeRepositoryNotFoundException:
+		sentinel = ErrRepositoryNotFound
+	case ecr.ErrCodeImageNotFoundException:
+		sentinel = ErrManifestNotFound
+	case "AccessDeniedException":
+		sentinel = ErrForbidden
+	case "ThrottlingException":
+		sentinel = ErrRateLimited
+	case ecr.ErrCodeServerException:
+		sentinel = ErrRegistryUnavailable
+	default:
+		return err
+	}
+	return &ecrAPIError{err: awsErr, sentinel: sentinel}
+}
+
+var ecrHostRegex = regexp.MustCompile(`^(\d{12})\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
+
+// parseECRHost splits a host like 123456789012.dkr.ecr.us-west-2.amazonaws.com into its registry ID and region
+func parseECRHost(host string) (registryID string, region string, ok bool) {
+	m := ecrHostRegex.FindStringSubmatch(host)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+func isECRHost(host string) bool {
+	_, _, ok := parseECRHost(host)
+	return ok
+}
+
+var _ RepositoryLister = &ECR{}
+
+// ListRepositories returns every ECR repository inside namespace
+func (e *ECR) ListRepositories(ctx context.Context, namespace string) ([]string, error) {
+	var ret []string
+	input := ecr.DescribeRepositoriesInput{
+		RegistryId: e.registryID(),
+	}
+	err := e.ECR.DescribeRepositoriesPagesWithContext(ctx, &input, func(output *ecr.DescribeRepositoriesOutput, _ bool) bool {
+		for _, r := range output.Repositories {
+			if name := aws.StringValue(r.RepositoryName); inNamespace(name, namespace) {
+				ret = append(ret, name)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe ECR repositories: %w", ecrError(err))
+	}
+	return ret, nil
+}
@@ -0,0 +1,164 @@
+package containerimagelisting
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/stretchr/testify/require"
+)
+
+type TestingECRAPIClient struct {
+	repositories []string
+	images       map[string][]*ecr.ImageDetail
+	// repositoriesErr is returned by DescribeRepositoriesPagesWithContext
+	repositoriesErr error
+	// registryIDs are the registry IDs images were described in
+	registryIDs []string
+}
+
+func (t *TestingECRAPIClient) DescribeImagesPagesWithContext(_ aws.Context, input *ecr.DescribeImagesInput, fn func(*ecr.DescribeImagesOutput, bool) bool, _ ...request.Option) error {
+	t.registryIDs = append(t.registryIDs, aws.StringValue(input.RegistryId))
+	images, exists := t.images[aws.StringValue(input.RepositoryName)]
+	if !exists {
+		return awserr.New(ecr.ErrCodeRepositoryNotFoundException, "The repository does not exist", nil)
+	}
+	for i, image := range images {
+		if !fn(&ecr.DescribeImagesOutput{ImageDetails: []*ecr.ImageDetail{image}}, i == len(images)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (t *TestingECRAPIClient) DescribeRepositoriesPagesWithContext(_ aws.Context, input *ecr.DescribeRepositoriesInput, fn func(*ecr.DescribeRepositoriesOutput, bool) bool, _ ...request.Option) error {
+	if t.repositoriesErr != nil {
+		return t.repositoriesErr
+	}
+	// One repository per page, to make sure we read every page
+	for i, r := range t.repositories {
+		out := &ecr.DescribeRepositoriesOutput{
+			Repositories: []*ecr.Repository{
+				{RepositoryName: aws.String(r), RegistryId: input.RegistryId},
+			},
+		}
+		if !fn(out, i == len(t.repositories)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestECR_ListRepositories(t *testing.T) {
+	e := ECR{
+		ECR: &TestingECRAPIClient{
+			repositories: []string{"cresta/a", "other/b", "cresta/c", "crestaish/d"},
+		},
+	}
+	ctx := context.Background()
+	repos, err := e.ListRepositories(ctx, "cresta")
+	require.NoError(t, err)
+	require.Equal(t, []string{"cresta/a", "cresta/c"}, repos)
+
+	repos, err = e.ListRepositories(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, repos, 4)
+
+	e.ECR = &TestingECRAPIClient{
+		repositoriesErr: awserr.New("AccessDeniedException", "not allowed", nil),
+	}
+	_, err = e.ListRepositories(ctx, "")
+	require.True(t, errors.Is(err, ErrForbidden))
+	var awsErr awserr.Error
+	require.True(t, errors.As(err, &awsErr))
+	require.Equal(t, "AccessDeniedException", awsErr.Code())
+}
+
+func TestECR_ListTags(t *testing.T) {
+	pushed := time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC)
+	e := ECR{
+		ECR: &TestingECRAPIClient{
+			images: map[string][]*ecr.ImageDetail{
+				"cresta/a": {
+					{
+						ImageTags:              aws.StringSlice([]string{"v1", "latest"}),
+						ImageDigest:            aws.String("sha256:abc"),
+						ImagePushedAt:          aws.Time(pushed),
+						ImageSizeInBytes:       aws.Int64(100),
+						ImageManifestMediaType: aws.String(MediaTypeOCIIndex),
+						ImageScanStatus:        &ecr.ImageScanStatus{Status: aws.String("COMPLETE")},
+					},
+					{
+						ImageTags:   aws.StringSlice([]string{"v0"}),
+						ImageDigest: aws.String("sha256:def"),
+					},
+				},
+			},
+		},
+	}
+	ctx := context.Background()
+	tags, err := e.ListTags(ctx, "cresta/a")
+	require.NoError(t, err)
+	require.Len(t, tags, 3)
+	require.Equal(t, "latest", tags[1].Tag())
+	require.Equal(t, "COMPLETE", tags[1].(*ECRTag).ScanStatus)
+	details, err := DetailsForTag(ctx, tags[1])
+	require.NoError(t, err)
+	require.Equal(t, &TagDetails{
+		Digest:    "sha256:abc",
+		Created:   pushed,
+		Size:      100,
+		MediaType: MediaTypeOCIIndex,
+		MultiArch: true,
+	}, details)
+
+	_, err = e.ListTags(ctx, "cresta/missing")
+	require.True(t, errors.Is(err, ErrRepositoryNotFound))
+	var awsErr awserr.Error
+	require.True(t, errors.As(err, &awsErr))
+	require.Equal(t, ecr.ErrCodeRepositoryNotFoundException, awsErr.Code())
+}
+
+func TestForNativeECRHosts(t *testing.T) {
+	images := map[string][]*ecr.ImageDetail{
+		"app": {{ImageTags: aws.StringSlice([]string{"v1"})}},
+	}
+	clients := map[string]*TestingECRAPIClient{
+		"us-west-2": {images: images},
+		"eu-west-1": {images: images},
+	}
+	registries, err := ForNativeECRHosts(nil, func(region string) (ECRAPIClient, error) {
+		return clients[region], nil
+	}, []string{
+		"https://123456789012.dkr.ecr.us-west-2.amazonaws.com",
+		"https://999999999999.dkr.ecr.eu-west-1.amazonaws.com",
+	})
+	require.NoError(t, err)
+	finder := RegistryFinder{Registries: registries}
+	ctx := context.Background()
+	_, err = finder.ListTags(ctx, "999999999999.dkr.ecr.eu-west-1.amazonaws.com/app")
+	require.NoError(t, err)
+	require.Equal(t, []string{"999999999999"}, clients["eu-west-1"].registryIDs)
+	require.Empty(t, clients["us-west-2"].registryIDs)
+
+	// Other accounts are not described in the account of the credentials
+	_, err = finder.ListTags(ctx, "111111111111.dkr.ecr.us-west-2.amazonaws.com/app")
+	require.True(t, errors.Is(err, ErrNoRegistryMatched))
+
+	_, err = ForNativeECR(clients["us-west-2"], "")
+	require.Error(t, err)
+}
+
+func TestParseECRHost(t *testing.T) {
+	registryID, region, ok := parseECRHost("123123123123.dkr.ecr.us-west-2.amazonaws.com")
+	require.True(t, ok)
+	require.Equal(t, "123123123123", registryID)
+	require.Equal(t, "us-west-2", region)
+	_, _, ok = parseECRHost("ghcr.io")
+	require.False(t, ok)
+}
@@ -20,14 +20,24 @@ type ECRClient interface {
 
 var _ ECRClient = &ecr.ECR{}
 
-// ECRAuthWrapper can wrap http.Request with the ECR Docker authentication token
+// ECRAuthWrapper can wrap http.Request with the ECR Docker authentication token.  Requests to hosts like
+// <account>.dkr.ecr.<region>.amazonaws.com use a token for that account and region, so one wrapper can be shared by
+// registries in many AWS accounts.
 type ECRAuthWrapper struct {
-	ECR                     ECRClient
+	ECR ECRClient
+	// ECRForRegion returns the client to use for registries in a region.  When nil, ECR is used for every region.
+	ECRForRegion            func(region string) (ECRClient, error)
 	AuthBufferTime          time.Duration
-	cachedAuthorizationData *ecr.AuthorizationData
+	cachedAuthorizationData map[ecrRegistryKey]*ecr.AuthorizationData
 	mu                      sync.Mutex
 }
 
+// ecrRegistryKey identifies the registry a token is for.  The zero value is the default registry of the credentials.
+type ecrRegistryKey struct {
+	registryID string
+	region     string
+}
+
 func (a *ECRAuthWrapper) authBufferTime() time.Duration {
 	if a.AuthBufferTime == 0 {
 		return time.Minute
@@ -35,11 +45,22 @@ func (a *ECRAuthWrapper) authBufferTime() time.Duration {
 	return a.AuthBufferTime
 }
 
+func (a *ECRAuthWrapper) clientForRegion(region string) (ECRClient, error) {
+	if region == "" || a.ECRForRegion == nil {
+		return a.ECR, nil
+	}
+	client, err := a.ECRForRegion(region)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create ECR client for region %s: %w", region, err)
+	}
+	return client, nil
+}
+
 var _ RequestWrapper = &ECRAuthWrapper{}
 
 // Wrap a http.Request with the docker token.  If the token is unknown or expired, will fetch it before wrapping.
 func (a *ECRAuthWrapper) Wrap(request *http.Request) error {
-	token, err := a.FetchToken(request.Context())
+	token, err := a.FetchTokenForHost(request.Context(), request.URL.Host)
 	if err != nil {
 		return fmt.Errorf("unable to fetch request token for ECR: %w", err)
 	}
@@ -50,23 +71,50 @@ func (a *ECRAuthWrapper) Wrap(request *http.Request) error {
 // FetchToken returns the ECR docker token.  It's possible to call this before using ECRAuthWrapper to verify
 // you are able to fetch a token.
 func (a *ECRAuthWrapper) FetchToken(ctx context.Context) (string, error) {
+	return a.fetchToken(ctx, ecrRegistryKey{})
+}
+
+// FetchTokenForHost returns the ECR docker token for the registry at host, like
+// 123456789012.dkr.ecr.us-west-2.amazonaws.com.  Hosts that do not look like ECR get the token of the default registry.
+func (a *ECRAuthWrapper) FetchTokenForHost(ctx context.Context, host string) (string, error) {
+	registryID, region, ok := parseECRHost(host)
+	if !ok {
+		return a.FetchToken(ctx)
+	}
+	return a.fetchToken(ctx, ecrRegistryKey{
+		registryID: registryID,
+		region:     region,
+	})
+}
+
+func (a *ECRAuthWrapper) fetchToken(ctx context.Context, key ecrRegistryKey) (string, error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	if a.cachedAuthorizationData != nil {
-		if a.cachedAuthorizationData.ExpiresAt.After(time.Now().Add(a.authBufferTime())) {
-			return *a.cachedAuthorizationData.AuthorizationToken, nil
+	if cached := a.cachedAuthorizationData[key]; cached != nil {
+		if cached.ExpiresAt.After(time.Now().Add(a.authBufferTime())) {
+			return *cached.AuthorizationToken, nil
 		}
 	}
 	var input ecr.GetAuthorizationTokenInput
+	if key.registryID != "" {
+		input.RegistryIds = aws.StringSlice([]string{key.registryID})
+	}
 
-	result, err := a.ECR.GetAuthorizationTokenWithContext(ctx, &input)
+	client, err := a.clientForRegion(key.region)
+	if err != nil {
+		return "", err
+	}
+	result, err := client.GetAuthorizationTokenWithContext(ctx, &input)
 	if err != nil {
 		return "", fmt.Errorf("error getting ECR authorization token: %w", err)
 	}
 	if len(result.AuthorizationData) < 1 {
 		return "", fmt.Errorf("unexpected return from ECR, expected at least one token, but got zero")
 	}
-	a.cachedAuthorizationData = result.AuthorizationData[0]
+	if a.cachedAuthorizationData == nil {
+		a.cachedAuthorizationData = make(map[ecrRegistryKey]*ecr.AuthorizationData)
+	}
+	a.cachedAuthorizationData[key] = result.AuthorizationData[0]
 
-	return *a.cachedAuthorizationData.AuthorizationToken, nil
+	return *result.AuthorizationData[0].AuthorizationToken, nil
 }
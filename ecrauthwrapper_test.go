@@ -33,3 +33,51 @@ func TestECRAuthWrapper_Wrap(t *testing.T) {
 	require.NoError(t, a.Wrap(req))
 	require.Equal(t, "Basic test_token", req.Header.Get("Authorization"))
 }
+
+type registryIDECRClient struct {
+	region string
+	calls  int
+}
+
+func (r *registryIDECRClient) GetAuthorizationTokenWithContext(_ aws.Context, input *ecr.GetAuthorizationTokenInput, _ ...request.Option) (*ecr.GetAuthorizationTokenOutput, error) {
+	r.calls++
+	return &ecr.GetAuthorizationTokenOutput{
+		AuthorizationData: []*ecr.AuthorizationData{
+			{
+				AuthorizationToken: aws.String(r.region + "_" + aws.StringValue(input.RegistryIds[0])),
+				ExpiresAt:          aws.Time(time.Now().Add(time.Hour)),
+			},
+		},
+	}, nil
+}
+
+func TestECRAuthWrapper_PerRegistry(t *testing.T) {
+	clients := map[string]*registryIDECRClient{
+		"us-west-2": {region: "us-west-2"},
+		"eu-west-1": {region: "eu-west-1"},
+	}
+	a := ECRAuthWrapper{
+		ECRForRegion: func(region string) (ECRClient, error) {
+			return clients[region], nil
+		},
+	}
+	checkToken := func(host string, expected string) {
+		req, err := http.NewRequest(http.MethodGet, "https://"+host+"/v2/", nil)
+		require.NoError(t, err)
+		require.NoError(t, a.Wrap(req))
+		require.Equal(t, "Basic "+expected, req.Header.Get("Authorization"))
+	}
+	checkToken("111111111111.dkr.ecr.us-west-2.amazonaws.com", "us-west-2_111111111111")
+	checkToken("222222222222.dkr.ecr.us-west-2.amazonaws.com", "us-west-2_222222222222")
+	checkToken("111111111111.dkr.ecr.eu-west-1.amazonaws.com", "eu-west-1_111111111111")
+	checkToken("111111111111.dkr.ecr.us-west-2.amazonaws.com", "us-west-2_111111111111")
+	require.Equal(t, 2, clients["us-west-2"].calls)
+	require.Equal(t, 1, clients["eu-west-1"].calls)
+}
+
+func TestForECR_Locator(t *testing.T) {
+	own := "123456789012.dkr.ecr.us-west-2.amazonaws.com"
+	r := ForECR(&TestingECRClient{}, "https://"+own, RegistryFinderOptionalConfig{})
+	require.Equal(t, "app", r.RepositoryLocator.RepositoryForURL(own+"/app"))
+	require.Empty(t, r.RepositoryLocator.RepositoryForURL("999999999999.dkr.ecr.eu-west-1.amazonaws.com/app"))
+}
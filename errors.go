@@ -0,0 +1,222 @@
+package containerimagelisting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrRepositoryNotFound is returned when the registry does not know about the requested repository
+	ErrRepositoryNotFound = errors.New("repository not found")
+	// ErrManifestNotFound is returned when the repository exists but the tag or digest does not
+	ErrManifestNotFound = errors.New("manifest not found")
+	// ErrBlobNotFound is returned when a blob, like an image config, does not exist in the repository
+	ErrBlobNotFound = errors.New("blob not found")
+	// ErrNoRegistryMatched is returned by RegistryFinder when no registry's RepositoryLocator matches the repository
+	ErrNoRegistryMatched = errors.New("no registry matched repository")
+	// ErrUnauthorized is returned when the registry requires credentials we do not have, or rejects the ones we sent
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is returned when the credentials are valid but not allowed to read the repository
+	ErrForbidden = errors.New("forbidden")
+	// ErrRateLimited is returned when the registry is throttling requests.  Use errors.As with *StatusError to find
+	// out how long the registry asked us to wait.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrRegistryUnavailable is returned when the registry fails with a server side error
+	ErrRegistryUnavailable = errors.New("registry unavailable")
+	// ErrInvalidReference is returned when an image reference, like ghcr.io/a/b:1.2, cannot be parsed
+	ErrInvalidReference = errors.New("invalid image reference")
+	// ErrUnsupported is returned when a registry cannot do what was asked of it, like listing platforms
+	ErrUnsupported = errors.New("unsupported by registry")
+)
+
+// StatusError is returned when a registry responds with an unexpected HTTP status code.  It matches one of the
+// sentinel errors above with errors.Is, depending on the status code.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	// RetryAfter is how long the registry asked us to wait before trying again, if it said
+	RetryAfter time.Duration
+	// err overrides the sentinel picked from StatusCode, for registries that tell us more precisely what went wrong
+	err error
+}
+
+func newStatusError(resp *http.Response) *StatusError {
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: parseRetryAfter(resp.Header, time.Now()),
+	}
+}
+
+func (s *StatusError) Error() string {
+	if s.RetryAfter > 0 {
+		return fmt.Sprintf("invalid status code %d with response %s (retry after %s)", s.StatusCode, s.Status, s.RetryAfter)
+	}
+	return fmt.Sprintf("invalid status code %d with response %s", s.StatusCode, s.Status)
+}
+
+// Unwrap returns the sentinel error for the status code, if there is one
+func (s *StatusError) Unwrap() error {
+	if s.err != nil {
+		return s.err
+	}
+	return errorForStatusCode(s.StatusCode)
+}
+
+// notFoundAs makes a 404 without a more precise error code match sentinel instead of ErrRepositoryNotFound, for
+// endpoints where a 404 means something other than a missing repository
+func notFoundAs(err error, sentinel error) error {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound && statusErr.err == nil {
+		statusErr.err = sentinel
+	}
+	return err
+}
+
+// errorForStatusCode picks the sentinel for a status code.  A 404 is taken to mean the repository does not exist,
+// which callers of other endpoints change with notFoundAs.
+func errorForStatusCode(statusCode int) error {
+	switch {
+	case statusCode == http.StatusNotFound:
+		return ErrRepositoryNotFound
+	case statusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case statusCode == http.StatusForbidden:
+		return ErrForbidden
+	case statusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case statusCode >= 500:
+		return ErrRegistryUnavailable
+	}
+	return nil
+}
+
+// parseRetryAfter reads the Retry-After header, which is either a number of seconds or an HTTP date.  Without
+// Retry-After, the RateLimit-Reset header docker hub and others send is used.
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	// Documented at https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Retry-After
+	val := header.Get("Retry-After")
+	if val == "" {
+		return parseRateLimitReset(header.Get("RateLimit-Reset"), now)
+	}
+	if seconds, err := strconv.Atoi(val); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(val); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// unixTimestampCutoff separates RateLimit-Reset values that are seconds from now from ones that are unix timestamps
+const unixTimestampCutoff = 1000000000
+
+// parseRateLimitReset reads a RateLimit-Reset header.  The IETF draft says it is the seconds until the limit resets,
+// but some registries send the unix timestamp it resets at instead.
+func parseRateLimitReset(val string, now time.Time) time.Duration {
+	// Documented at https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/
+	seconds, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	if seconds < unixTimestampCutoff {
+		return time.Duration(seconds) * time.Second
+	}
+	if resetAt := time.Unix(seconds, 0); resetAt.After(now) {
+		return resetAt.Sub(now)
+	}
+	return 0
+}
+
+// DockerV2ErrorDetail is a single entry of the error body a Docker v2 registry returns
+type DockerV2ErrorDetail struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Detail  interface{} `json:"detail,omitempty"`
+}
+
+func (d DockerV2ErrorDetail) String() string {
+	if d.Detail != nil {
+		return fmt.Sprintf("%s: %s (%v)", d.Code, d.Message, d.Detail)
+	}
+	return fmt.Sprintf("%s: %s", d.Code, d.Message)
+}
+
+// DockerV2Error is returned when a Docker v2 registry rejects a request and explains why in its response body.  The
+// embedded StatusError matches the sentinel errors with errors.Is, using the error codes when they are known.
+type DockerV2Error struct {
+	*StatusError
+	Errors []DockerV2ErrorDetail
+}
+
+// newDockerV2Error decodes the Docker v2 error envelope from body.  If the body is not an error envelope, a plain
+// StatusError is returned instead.
+func newDockerV2Error(resp *http.Response, body []byte) error {
+	// Documented at https://docs.docker.com/registry/spec/api/#errors
+	var envelope struct {
+		Errors []DockerV2ErrorDetail `json:"errors"`
+	}
+	statusErr := newStatusError(resp)
+	if err := json.Unmarshal(body, &envelope); err != nil || len(envelope.Errors) == 0 {
+		return statusErr
+	}
+	for _, e := range envelope.Errors {
+		if sentinel := errorForDockerV2Code(e.Code); sentinel != nil {
+			statusErr.err = sentinel
+			break
+		}
+	}
+	return &DockerV2Error{
+		StatusError: statusErr,
+		Errors:      envelope.Errors,
+	}
+}
+
+// Codes documented at https://docs.docker.com/registry/spec/api/#errors-2
+func errorForDockerV2Code(code string) error {
+	switch code {
+	case "NAME_UNKNOWN":
+		return ErrRepositoryNotFound
+	case "MANIFEST_UNKNOWN":
+		return ErrManifestNotFound
+	case "BLOB_UNKNOWN":
+		return ErrBlobNotFound
+	case "UNAUTHORIZED":
+		return ErrUnauthorized
+	case "DENIED":
+		return ErrForbidden
+	case "TOOMANYREQUESTS":
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// Codes returns the error codes the registry sent, like NAME_UNKNOWN
+func (d *DockerV2Error) Codes() []string {
+	ret := make([]string, 0, len(d.Errors))
+	for _, e := range d.Errors {
+		ret = append(ret, e.Code)
+	}
+	return ret
+}
+
+func (d *DockerV2Error) Error() string {
+	details := make([]string, 0, len(d.Errors))
+	for _, e := range d.Errors {
+		details = append(details, e.String())
+	}
+	return fmt.Sprintf("%s: %s", d.StatusError.Error(), strings.Join(details, "; "))
+}
+
+// Unwrap returns the StatusError, so errors.As can still find it
+func (d *DockerV2Error) Unwrap() error {
+	return d.StatusError
+}
@@ -0,0 +1,89 @@
+package containerimagelisting
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusError(t *testing.T) {
+	header := make(http.Header)
+	header.Set("Retry-After", "30")
+	var err error = newStatusError(&http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Status:     "429 Too Many Requests",
+		Header:     header,
+	})
+	require.True(t, errors.Is(err, ErrRateLimited))
+	require.False(t, errors.Is(err, ErrUnauthorized))
+	var statusErr *StatusError
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, 30*time.Second, statusErr.RetryAfter)
+
+	require.True(t, errors.Is(&StatusError{StatusCode: http.StatusNotFound}, ErrRepositoryNotFound))
+	require.True(t, errors.Is(&StatusError{StatusCode: http.StatusBadGateway}, ErrRegistryUnavailable))
+	require.Nil(t, errors.Unwrap(&StatusError{StatusCode: http.StatusTeapot}))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC)
+	testFunc := func(given string, expected time.Duration) func(t *testing.T) {
+		return func(t *testing.T) {
+			header := make(http.Header)
+			header.Set("Retry-After", given)
+			require.Equal(t, expected, parseRetryAfter(header, now))
+		}
+	}
+	t.Run("empty", testFunc("", 0))
+	t.Run("seconds", testFunc("120", 2*time.Minute))
+	t.Run("date", testFunc(now.Add(time.Minute).Format(http.TimeFormat), time.Minute))
+	t.Run("garbage", testFunc("soon", 0))
+
+	rateLimitFunc := func(given string, expected time.Duration) func(t *testing.T) {
+		return func(t *testing.T) {
+			header := make(http.Header)
+			header.Set("RateLimit-Reset", given)
+			require.Equal(t, expected, parseRetryAfter(header, now))
+		}
+	}
+	t.Run("rate_limit_seconds", rateLimitFunc("30", 30*time.Second))
+	t.Run("rate_limit_timestamp", rateLimitFunc(strconv.FormatInt(now.Add(time.Hour).Unix(), 10), time.Hour))
+	t.Run("rate_limit_past", rateLimitFunc(strconv.FormatInt(now.Add(-time.Hour).Unix(), 10), 0))
+}
+
+func TestNotFoundByEndpoint(t *testing.T) {
+	d := DockerV2{
+		BaseURL: "http://example.com",
+		Client: &http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				body := ""
+				if r.URL.Path == "/v2/test_repo/blobs/sha256:coded" {
+					body = `{"errors": [{"code": "BLOB_UNKNOWN", "message": "blob unknown to registry"}]}`
+				}
+				return &http.Response{
+					StatusCode: http.StatusNotFound,
+					Status:     "404 Not Found",
+					Body:       ioutil.NopCloser(strings.NewReader(body)),
+				}, nil
+			}),
+		},
+	}
+	ctx := context.Background()
+	for _, digest := range []string{"sha256:plain", "sha256:coded"} {
+		_, err := d.GetBlob(ctx, "test_repo", digest)
+		require.True(t, errors.Is(err, ErrBlobNotFound), digest)
+		require.False(t, errors.Is(err, ErrRepositoryNotFound), digest)
+	}
+	_, err := d.ListRepositories(ctx, "")
+	require.True(t, errors.Is(err, ErrUnsupported))
+	require.False(t, errors.Is(err, ErrRepositoryNotFound))
+	_, err = d.ListTags(ctx, "test_repo")
+	require.True(t, errors.Is(err, ErrRepositoryNotFound))
+}
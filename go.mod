@@ -3,7 +3,7 @@ module github.com/cresta/container-image-listing
 go 1.16
 
 require (
-	github.com/aws/aws-sdk-go v1.40.21
+	github.com/aws/aws-sdk-go v1.43.11
 	github.com/caarlos0/env/v6 v6.6.2
 	github.com/cresta/magehelper v0.0.56
 	github.com/stretchr/testify v1.7.0
@@ -0,0 +1,153 @@
+package containerimagelisting
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ImageConfig is the part of an image's config blob that says when and for which platform the image was built
+type ImageConfig struct {
+	Created      time.Time
+	Architecture string
+	OS           string
+	OSVersion    string
+	Variant      string
+	Labels       map[string]string
+}
+
+// GetBlob downloads the blob with the given digest from a repository
+func (c *DockerV2) GetBlob(ctx context.Context, repository string, digest string) ([]byte, error) {
+	// Documented at https://docs.docker.com/registry/spec/api/#pulling-a-layer
+	resp, body, err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s/v2/%s/blobs/%s", c.BaseURL, repository, digest), nil, repositoryAuthKey(repository))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, notFoundAs(newDockerV2Error(resp, body), ErrBlobNotFound)
+	}
+	if strings.HasPrefix(digest, "sha256:") {
+		if computed := fmt.Sprintf("sha256:%x", sha256.Sum256(body)); computed != digest {
+			return nil, fmt.Errorf("blob digest mismatch: expected %s but got %s", digest, computed)
+		}
+	}
+	return body, nil
+}
+
+// GetImageConfig returns the image config for reference, which is either a tag or a digest.  For manifest lists and
+// OCI indexes, the config of the linux/amd64 image is returned, or the first image if there is no linux/amd64 one.
+func (c *DockerV2) GetImageConfig(ctx context.Context, repository string, reference string) (*ImageConfig, error) {
+	m, err := c.GetManifest(ctx, repository, reference)
+	if err != nil {
+		return nil, err
+	}
+	return c.imageConfigForManifest(ctx, repository, m)
+}
+
+func (c *DockerV2) imageConfigForManifest(ctx context.Context, repository string, m *ManifestResponse) (*ImageConfig, error) {
+	if m.IsIndex() {
+		desc := defaultPlatformManifest(m.Manifest.Manifests)
+		if desc == nil {
+			return nil, fmt.Errorf("manifest %s does not contain any images", m.Digest)
+		}
+		child, err := c.GetManifest(ctx, repository, desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch platform manifest %s: %w", desc.Digest, err)
+		}
+		m = child
+	}
+	if !isImageManifest(m.MediaType) {
+		return nil, fmt.Errorf("manifest %s has media type %q: %w", m.Digest, m.MediaType, ErrUnsupported)
+	}
+	if m.Manifest.Config == nil {
+		return nil, fmt.Errorf("manifest %s does not have a config", m.Digest)
+	}
+	blob, err := c.GetBlob(ctx, repository, m.Manifest.Config.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch config blob %s: %w", m.Manifest.Config.Digest, err)
+	}
+	return parseImageConfig(blob)
+}
+
+func parseImageConfig(blob []byte) (*ImageConfig, error) {
+	// Documented at https://github.com/opencontainers/image-spec/blob/main/config.md
+	var raw struct {
+		Created      time.Time `json:"created"`
+		Architecture string    `json:"architecture"`
+		OS           string    `json:"os"`
+		OSVersion    string    `json:"os.version"`
+		Variant      string    `json:"variant"`
+		Config       struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(blob, &raw); err != nil {
+		return nil, fmt.Errorf("unable to decode image config: %w", err)
+	}
+	return &ImageConfig{
+		Created:      raw.Created,
+		Architecture: raw.Architecture,
+		OS:           raw.OS,
+		OSVersion:    raw.OSVersion,
+		Variant:      raw.Variant,
+		Labels:       raw.Config.Labels,
+	}, nil
+}
+
+// defaultPlatformManifest picks the image from an index that we use to describe the whole index
+func defaultPlatformManifest(manifests []Descriptor) *Descriptor {
+	var first *Descriptor
+	for i := range manifests {
+		if manifests[i].isAttestation() {
+			continue
+		}
+		p := manifests[i].Platform
+		if p != nil && p.OS == "linux" && p.Architecture == "amd64" {
+			return &manifests[i]
+		}
+		if first == nil {
+			first = &manifests[i]
+		}
+	}
+	return first
+}
+
+func (c *DockerV2) enrichConcurrency() int {
+	if c.EnrichConcurrency <= 0 {
+		return 4
+	}
+	return c.EnrichConcurrency
+}
+
+// enrichTags fetches the details of every tag, with at most enrichConcurrency requests in flight at a time.  Tags whose
+// details cannot be fetched, like a tag deleted since it was listed, are left without them, so calling Details on them
+// later tries again and returns the error.  Only ctx ending stops enrichment early.
+func (c *DockerV2) enrichTags(ctx context.Context, tags []*DockerV2Tag) error {
+	work := make(chan *DockerV2Tag)
+	var wg sync.WaitGroup
+	for i := 0; i < c.enrichConcurrency(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range work {
+				_, _ = t.Details(ctx)
+			}
+		}()
+	}
+send:
+	for _, t := range tags {
+		select {
+		case work <- t:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(work)
+	wg.Wait()
+	return ctx.Err()
+}
@@ -0,0 +1,243 @@
+package containerimagelisting
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Manifest media types that DockerV2 asks for when fetching manifests
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+var manifestAcceptHeader = strings.Join([]string{
+	MediaTypeDockerManifest,
+	MediaTypeDockerManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeOCIIndex,
+}, ", ")
+
+// Platform describes the platform an image runs on, as found in manifest lists and OCI indexes
+type Platform struct {
+	Architecture string   `json:"architecture"`
+	OS           string   `json:"os"`
+	OSVersion    string   `json:"os.version,omitempty"`
+	OSFeatures   []string `json:"os.features,omitempty"`
+	Variant      string   `json:"variant,omitempty"`
+}
+
+// Descriptor points to content by digest, like a layer, a config blob or a child manifest
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *Platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// isAttestation returns true for the build attestations buildkit adds to indexes, which show up as the platform
+// unknown/unknown and are not runnable images
+func (d *Descriptor) isAttestation() bool {
+	return d.Platform != nil && d.Platform.OS == "unknown"
+}
+
+// Manifest is the union of the Docker schema2 manifest, Docker manifest list, OCI image manifest and OCI index formats.
+// Image manifests fill in Config and Layers, while manifest lists and indexes fill in Manifests.
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        *Descriptor       `json:"config,omitempty"`
+	Layers        []Descriptor      `json:"layers,omitempty"`
+	Manifests     []Descriptor      `json:"manifests,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// ManifestResponse is what a registry told us about a manifest
+type ManifestResponse struct {
+	// Digest is the Docker-Content-Digest of the manifest, like sha256:abc...
+	Digest    string
+	MediaType string
+	Size      int64
+	// Manifest is the parsed manifest.  It is nil for HeadManifest.
+	Manifest *Manifest
+	// Raw is the manifest exactly as the registry returned it.  It is nil for HeadManifest.
+	Raw []byte
+}
+
+// IsIndex returns true if the manifest is a Docker manifest list or an OCI index, which point to one manifest per
+// platform
+func (m *ManifestResponse) IsIndex() bool {
+	return m.MediaType == MediaTypeDockerManifestList || m.MediaType == MediaTypeOCIIndex
+}
+
+// GetManifest fetches and parses the manifest for reference, which is either a tag or a digest
+func (c *DockerV2) GetManifest(ctx context.Context, repository string, reference string) (*ManifestResponse, error) {
+	return c.fetchManifest(ctx, http.MethodGet, repository, reference)
+}
+
+// HeadManifest returns the digest, media type and size of the manifest for reference without downloading it
+func (c *DockerV2) HeadManifest(ctx context.Context, repository string, reference string) (*ManifestResponse, error) {
+	return c.fetchManifest(ctx, http.MethodHead, repository, reference)
+}
+
+// ResolveDigest returns the digest a tag currently points to
+func (c *DockerV2) ResolveDigest(ctx context.Context, repository string, tag string) (string, error) {
+	head, err := c.HeadManifest(ctx, repository, tag)
+	if err != nil {
+		return "", err
+	}
+	if head.Digest != "" {
+		return head.Digest, nil
+	}
+	// Not every registry sends Docker-Content-Digest for HEAD requests.  GET will compute it from the body.
+	full, err := c.GetManifest(ctx, repository, tag)
+	if err != nil {
+		return "", err
+	}
+	return full.Digest, nil
+}
+
+func (c *DockerV2) fetchManifest(ctx context.Context, method string, repository string, reference string) (*ManifestResponse, error) {
+	if cached := c.cachedManifest(ctx, method, repository, reference); cached != nil {
+		return cached, nil
+	}
+	// Documented at https://docs.docker.com/registry/spec/api/#pulling-an-image-manifest
+	header := make(http.Header)
+	header.Set("Accept", manifestAcceptHeader)
+	resp, body, err := c.do(ctx, method, fmt.Sprintf("%s/v2/%s/manifests/%s", c.BaseURL, repository, reference), header, repositoryAuthKey(repository))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, manifestError(newDockerV2Error(resp, body))
+	}
+
+	ret := ManifestResponse{
+		Digest:    resp.Header.Get("Docker-Content-Digest"),
+		MediaType: manifestMediaType(resp.Header.Get("Content-Type")),
+		Size:      resp.ContentLength,
+	}
+	if method == http.MethodHead {
+		if ret.Size < 0 {
+			ret.Size, _ = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+		}
+		return &ret, nil
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("unable to decode manifest: %w", err)
+	}
+	ret.Manifest = &m
+	ret.Raw = body
+	ret.Size = int64(len(body))
+	if ret.Digest == "" {
+		ret.Digest = fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	}
+	if ret.MediaType == "" {
+		ret.MediaType = m.mediaType()
+	}
+	c.storeManifest(ctx, repository, &ret)
+	return &ret, nil
+}
+
+// cachedManifestBody is what DockerV2 stores in its Cache for a manifest
+type cachedManifestBody struct {
+	MediaType string `json:"media_type"`
+	Raw       []byte `json:"raw"`
+}
+
+func manifestCacheKey(baseURL string, repository string, digest string) string {
+	return cacheKey("v2", "manifests", hostOfURL(baseURL), repository, digest)
+}
+
+// cachedManifest returns the manifest for reference from Cache, or nil.  Only digests are looked up, since what a
+// digest points to never changes, while tags move.
+func (c *DockerV2) cachedManifest(ctx context.Context, method string, repository string, reference string) *ManifestResponse {
+	if c.Cache == nil || !isDigest(reference) {
+		return nil
+	}
+	var cached cachedManifestBody
+	if status, err := c.Cache.Get(ctx, manifestCacheKey(c.BaseURL, repository, reference), &cached); err != nil || status == CacheMiss {
+		return nil
+	}
+	ret := ManifestResponse{
+		Digest:    reference,
+		MediaType: cached.MediaType,
+		Size:      int64(len(cached.Raw)),
+	}
+	if method == http.MethodHead {
+		return &ret
+	}
+	var m Manifest
+	if err := json.Unmarshal(cached.Raw, &m); err != nil {
+		return nil
+	}
+	ret.Manifest = &m
+	ret.Raw = cached.Raw
+	return &ret
+}
+
+// storeManifest stores m in Cache forever, under its digest.  Manifests whose body does not match their digest are
+// not stored, so a misbehaving registry cannot put the wrong content under a digest.
+func (c *DockerV2) storeManifest(ctx context.Context, repository string, m *ManifestResponse) {
+	if c.Cache == nil || m.Digest != fmt.Sprintf("sha256:%x", sha256.Sum256(m.Raw)) {
+		return
+	}
+	_ = c.Cache.Set(ctx, manifestCacheKey(c.BaseURL, repository, m.Digest), cachedManifestBody{
+		MediaType: m.MediaType,
+		Raw:       m.Raw,
+	}, 0)
+}
+
+// mediaType guesses the media type from the manifest body, for registries that do not send a useful Content-Type.  It
+// is empty if the body does not look like any of the formats DockerV2 asks for, like a schema1 manifest.
+func (m *Manifest) mediaType() string {
+	if m.MediaType != "" {
+		return m.MediaType
+	}
+	if m.SchemaVersion != 2 {
+		return ""
+	}
+	// OCI manifests are allowed to leave out mediaType
+	if len(m.Manifests) > 0 {
+		return MediaTypeOCIIndex
+	}
+	if m.Config != nil {
+		return MediaTypeOCIManifest
+	}
+	return ""
+}
+
+// manifestMediaType returns the media type the registry sent, or nothing if it only said the manifest is JSON
+func manifestMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch mediaType {
+	case "application/json", "text/plain", "application/octet-stream":
+		return ""
+	}
+	return mediaType
+}
+
+// isImageManifest returns true for the manifest formats that point to an image config
+func isImageManifest(mediaType string) bool {
+	return mediaType == MediaTypeDockerManifest || mediaType == MediaTypeOCIManifest
+}
+
+// manifestError turns a plain 404 into ErrManifestNotFound, since HEAD responses have no body to tell us which part
+// of the request was unknown
+func manifestError(err error) error {
+	return notFoundAs(err, ErrManifestNotFound)
+}
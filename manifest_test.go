@@ -0,0 +1,106 @@
+package containerimagelisting
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerV2_GetManifest(t *testing.T) {
+	d := DockerV2{
+		BaseURL: "http://example.com",
+		Client: &http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				require.Equal(t, "/v2/test_repo/manifests/latest", r.URL.Path)
+				require.Contains(t, r.Header.Get("Accept"), MediaTypeOCIIndex)
+				header := make(http.Header)
+				header.Set("Content-Type", MediaTypeDockerManifestList)
+				header.Set("Docker-Content-Digest", "sha256:abc")
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     header,
+					Body: ioutil.NopCloser(strings.NewReader(`{
+"schemaVersion": 2,
+"mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+"manifests": [{"mediaType": "application/vnd.docker.distribution.manifest.v2+json", "digest": "sha256:def", "size": 10, "platform": {"architecture": "arm64", "os": "linux", "variant": "v8"}}]
+}`)),
+				}, nil
+			}),
+		},
+	}
+	m, err := d.GetManifest(context.Background(), "test_repo", "latest")
+	require.NoError(t, err)
+	require.Equal(t, "sha256:abc", m.Digest)
+	require.True(t, m.IsIndex())
+	require.Len(t, m.Manifest.Manifests, 1)
+	require.Equal(t, "sha256:def", m.Manifest.Manifests[0].Digest)
+	require.Equal(t, "arm64", m.Manifest.Manifests[0].Platform.Architecture)
+}
+
+func TestDockerV2_GetManifestSchema1(t *testing.T) {
+	contentType := "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	d := DockerV2{
+		BaseURL: "http://example.com",
+		Client: &http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				header := make(http.Header)
+				header.Set("Content-Type", contentType)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     header,
+					Body:       ioutil.NopCloser(strings.NewReader(`{"schemaVersion": 1, "name": "test_repo", "tag": "old", "fsLayers": []}`)),
+				}, nil
+			}),
+		},
+	}
+	ctx := context.Background()
+	m, err := d.GetManifest(ctx, "test_repo", "old")
+	require.NoError(t, err)
+	require.Equal(t, contentType, m.MediaType)
+	_, err = d.GetImageConfig(ctx, "test_repo", "old")
+	require.ErrorIs(t, err, ErrUnsupported)
+
+	// Without a useful Content-Type, the body is not guessed to be an OCI manifest either
+	contentType = "application/json"
+	m, err = d.GetManifest(ctx, "test_repo", "old")
+	require.NoError(t, err)
+	require.Equal(t, "", m.MediaType)
+	_, err = d.GetImageConfig(ctx, "test_repo", "old")
+	require.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestDockerV2_ResolveDigest(t *testing.T) {
+	d := DockerV2{
+		BaseURL: "http://example.com",
+		Client: &http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				require.Equal(t, http.MethodHead, r.Method)
+				if strings.HasSuffix(r.URL.Path, "/missing") {
+					return &http.Response{
+						StatusCode: http.StatusNotFound,
+						Body:       ioutil.NopCloser(strings.NewReader("")),
+					}, nil
+				}
+				header := make(http.Header)
+				header.Set("Docker-Content-Digest", "sha256:abc")
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     header,
+					Body:       ioutil.NopCloser(strings.NewReader("")),
+				}, nil
+			}),
+		},
+	}
+	ctx := context.Background()
+	digest, err := d.ResolveDigest(ctx, "test_repo", "latest")
+	require.NoError(t, err)
+	require.Equal(t, "sha256:abc", digest)
+
+	_, err = d.ResolveDigest(ctx, "test_repo", "missing")
+	require.True(t, errors.Is(err, ErrManifestNotFound))
+}
@@ -0,0 +1,126 @@
+package containerimagelisting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Mirror is a registry that caches another registry, like a Harbor proxy cache in front of docker hub.  RegistryFinder
+// asks mirrors before the upstream registry, and falls back to the upstream when the mirror does not have the
+// repository or cannot be reached.
+type Mirror struct {
+	// Upstream is the host the mirror caches, like docker.io or ghcr.io
+	Upstream string
+	// Registry lists tags from the mirror
+	Registry Registry
+	// RepositoryPrefix is put in front of upstream repositories, like dockerhub-proxy for dockerhub-proxy/library/redis
+	RepositoryPrefix string
+	// Name is reported as the Source of tags the mirror answered with.  Defaults to "<Upstream> mirror".
+	Name string
+}
+
+func (m *Mirror) name() string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return m.Upstream + " mirror"
+}
+
+func (m *Mirror) repository(upstreamRepository string) string {
+	if m.RepositoryPrefix == "" {
+		return upstreamRepository
+	}
+	return strings.TrimSuffix(m.RepositoryPrefix, "/") + "/" + upstreamRepository
+}
+
+// ListTagsResult is the answer of RegistryFinder.ListTagsWithSource
+type ListTagsResult struct {
+	Tags []Tag
+	// Source is the Name of the mirror or registry that answered.  Registries without a Name are reported by host.
+	Source string
+	// MirrorErrors are why mirrors tried before Source did not answer
+	MirrorErrors []error
+}
+
+// ListTagsWithSource lists tags like ListTags, trying the mirrors of the repository's host first, and reports which
+// mirror or registry answered
+func (r *RegistryFinder) ListTagsWithSource(ctx context.Context, repository string) (*ListTagsResult, error) {
+	ref, err := ParseImageReference(repository)
+	if err != nil {
+		return nil, err
+	}
+	host := referenceHost(ref)
+	upstream, upstreamRepository := r.findRegistry(ref)
+	if upstream == nil {
+		upstreamRepository = ref.Path
+		if host == "docker.io" {
+			upstreamRepository = dockerHubRepository(ref.Path)
+		}
+	}
+
+	var ret ListTagsResult
+	for i := range r.Mirrors {
+		m := &r.Mirrors[i]
+		if registryHostOf(m.Upstream) != host {
+			continue
+		}
+		tags, err := m.Registry.ListTags(ctx, m.repository(upstreamRepository))
+		if err == nil {
+			ret.Tags = tags
+			ret.Source = m.name()
+			return &ret, nil
+		}
+		if ctx.Err() != nil || !shouldFallBack(err) {
+			return nil, fmt.Errorf("unable to list tags from %s: %w", m.name(), err)
+		}
+		ret.MirrorErrors = append(ret.MirrorErrors, fmt.Errorf("unable to list tags from %s: %w", m.name(), err))
+	}
+	if upstream == nil {
+		if len(ret.MirrorErrors) > 0 {
+			return nil, ret.MirrorErrors[len(ret.MirrorErrors)-1]
+		}
+		return nil, fmt.Errorf("unable to find registry for %s: %w", repository, ErrNoRegistryMatched)
+	}
+	tags, err := upstream.Registry.ListTags(ctx, upstreamRepository)
+	if err != nil {
+		return nil, err
+	}
+	ret.Tags = tags
+	ret.Source = upstream.Name
+	if ret.Source == "" {
+		ret.Source = host
+	}
+	return &ret, nil
+}
+
+// findRegistry returns the first registry whose locator matches ref, and the repository it found
+func (r *RegistryFinder) findRegistry(ref *ImageReference) (*RegistryWithFinder, string) {
+	for i := range r.Registries {
+		if repo := locateRepository(r.Registries[i].RepositoryLocator, ref); repo != "" {
+			return &r.Registries[i], repo
+		}
+	}
+	return nil, ""
+}
+
+// referenceHost is the registry host of ref, with docker hub written as docker.io
+func referenceHost(ref *ImageReference) string {
+	if ref.Domain == "" {
+		return "docker.io"
+	}
+	return registryHostOf(ref.Domain)
+}
+
+// shouldFallBack returns true for errors a mirror can have that the upstream registry may not
+func shouldFallBack(err error) bool {
+	var urlErr *url.Error
+	return errors.Is(err, ErrRepositoryNotFound) ||
+		errors.Is(err, ErrManifestNotFound) ||
+		errors.Is(err, ErrBlobNotFound) ||
+		errors.Is(err, ErrRegistryUnavailable) ||
+		errors.Is(err, ErrRateLimited) ||
+		errors.As(err, &urlErr)
+}
@@ -0,0 +1,76 @@
+package containerimagelisting
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryFinder_Mirrors(t *testing.T) {
+	var asked []string
+	mirrorErr := error(nil)
+	finder := RegistryFinder{
+		Registries: []RegistryWithFinder{
+			{
+				Registry: &registryFunc{listTags: func(ctx context.Context, repository string) ([]Tag, error) {
+					asked = append(asked, "upstream "+repository)
+					return []Tag{&staticTag{tag: "upstream"}}, nil
+				}},
+				RepositoryLocator: &DockerHubLocator{},
+			},
+		},
+		Mirrors: []Mirror{
+			{
+				Upstream: "docker.io",
+				Registry: &registryFunc{listTags: func(ctx context.Context, repository string) ([]Tag, error) {
+					asked = append(asked, "mirror "+repository)
+					if mirrorErr != nil {
+						return nil, mirrorErr
+					}
+					return []Tag{&staticTag{tag: "mirror"}}, nil
+				}},
+				RepositoryPrefix: "dockerhub-proxy",
+				Name:             "harbor",
+			},
+		},
+	}
+	ctx := context.Background()
+
+	res, err := finder.ListTagsWithSource(ctx, "index.docker.io/redis:7")
+	require.NoError(t, err)
+	require.Equal(t, "harbor", res.Source)
+	require.Equal(t, []string{"mirror"}, tagNames(res.Tags))
+	require.Equal(t, []string{"mirror dockerhub-proxy/library/redis"}, asked)
+
+	fallbacks := []error{
+		&StatusError{StatusCode: 404},
+		&StatusError{StatusCode: 503},
+		&url.Error{Op: "Get", URL: "https://harbor.example.com", Err: errors.New("connection refused")},
+	}
+	for _, mirrorErr = range fallbacks {
+		asked = nil
+		res, err = finder.ListTagsWithSource(ctx, "cresta/app")
+		require.NoError(t, err)
+		require.Equal(t, "docker.io", res.Source)
+		require.Equal(t, []string{"upstream"}, tagNames(res.Tags))
+		require.Equal(t, []string{"mirror dockerhub-proxy/cresta/app", "upstream cresta/app"}, asked)
+		require.Len(t, res.MirrorErrors, 1)
+		require.True(t, errors.Is(res.MirrorErrors[0], mirrorErr))
+	}
+
+	// Errors the upstream would also have are not hidden
+	mirrorErr = &StatusError{StatusCode: 401}
+	asked = nil
+	_, err = finder.ListTags(ctx, "cresta/app")
+	require.ErrorIs(t, err, ErrUnauthorized)
+	require.Equal(t, []string{"mirror dockerhub-proxy/cresta/app"}, asked)
+
+	// Mirrors only apply to their upstream host
+	asked = nil
+	_, err = finder.ListTags(ctx, "ghcr.io/a/b")
+	require.ErrorIs(t, err, ErrNoRegistryMatched)
+	require.Empty(t, asked)
+}
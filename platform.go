@@ -0,0 +1,121 @@
+package containerimagelisting
+
+import (
+	"context"
+	"strings"
+)
+
+// PlatformLister is implemented by registries that can tell which platforms an image was built for
+type PlatformLister interface {
+	// ListPlatforms returns one PlatformImage per platform the tag or digest reference was built for
+	ListPlatforms(ctx context.Context, repository string, reference string) ([]PlatformImage, error)
+}
+
+// PlatformImage is the image for a single platform inside a (possibly multi-arch) tag
+type PlatformImage struct {
+	Platform
+	// Digest of the platform specific manifest
+	Digest    string
+	MediaType string
+	Size      int64
+}
+
+// String returns the platform in the os/architecture[/variant] form docker uses, like linux/arm64/v8
+func (p Platform) String() string {
+	parts := []string{p.OS, p.Architecture}
+	if p.Variant != "" {
+		parts = append(parts, p.Variant)
+	}
+	return strings.Join(parts, "/")
+}
+
+// HasPlatform returns true if platforms contains platform, written like linux/arm64 or linux/arm64/v8.  A platform
+// without a variant matches any variant.
+func HasPlatform(platforms []PlatformImage, platform string) bool {
+	want := strings.Split(platform, "/")
+	for _, p := range platforms {
+		if len(want) < 2 || p.OS != want[0] || p.Architecture != want[1] {
+			continue
+		}
+		if len(want) == 2 || p.Variant == want[2] {
+			return true
+		}
+	}
+	return false
+}
+
+var _ PlatformLister = &DockerV2{}
+
+// ListPlatforms returns the platforms in a manifest list or OCI index.  For single platform images, the platform is read
+// from the image config.
+func (c *DockerV2) ListPlatforms(ctx context.Context, repository string, reference string) ([]PlatformImage, error) {
+	m, err := c.GetManifest(ctx, repository, reference)
+	if err != nil {
+		return nil, err
+	}
+	if !m.IsIndex() {
+		cfg, err := c.imageConfigForManifest(ctx, repository, m)
+		if err != nil {
+			return nil, err
+		}
+		return []PlatformImage{
+			{
+				Platform: Platform{
+					Architecture: cfg.Architecture,
+					OS:           cfg.OS,
+					OSVersion:    cfg.OSVersion,
+					Variant:      cfg.Variant,
+				},
+				Digest:    m.Digest,
+				MediaType: m.MediaType,
+				Size:      m.Size,
+			},
+		}, nil
+	}
+	ret := make([]PlatformImage, 0, len(m.Manifest.Manifests))
+	for _, desc := range m.Manifest.Manifests {
+		if desc.Platform == nil || desc.isAttestation() {
+			continue
+		}
+		ret = append(ret, PlatformImage{
+			Platform:  *desc.Platform,
+			Digest:    desc.Digest,
+			MediaType: desc.MediaType,
+			Size:      desc.Size,
+		})
+	}
+	return ret, nil
+}
+
+var _ PlatformLister = &Quay{}
+
+// ListPlatforms uses quay's Docker v2 API, since the quay API does not describe platforms
+func (q *Quay) ListPlatforms(ctx context.Context, repository string, reference string) ([]PlatformImage, error) {
+	return q.dockerV2().ListPlatforms(ctx, repository, reference)
+}
+
+// dockerV2 returns quay's Docker v2 API, which is built the first time it is needed
+func (q *Quay) dockerV2() *DockerV2 {
+	q.v2Mu.Lock()
+	defer q.v2Mu.Unlock()
+	if q.v2 != nil {
+		return q.v2
+	}
+	ret := &DockerV2{
+		BaseURL: q.baseURL(),
+		Client:  q.Client,
+		ReAuth: &ScopeReauther{
+			Credentials: q.Credentials,
+			Retry:       q.Retry,
+		},
+		Retry:    q.Retry,
+		Cache:    q.Cache,
+		CacheTTL: q.CacheTTL,
+	}
+	if q.Token != "" {
+		ret.ReAuth.Username = quayOAuthTokenUsername
+		ret.ReAuth.Password = q.Token
+	}
+	q.v2 = ret
+	return ret
+}
@@ -0,0 +1,38 @@
+package containerimagelisting
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerV2_ListPlatforms(t *testing.T) {
+	d := DockerV2{
+		BaseURL: "http://example.com",
+		Client:  &http.Client{Transport: newFakeDockerV2Server()},
+	}
+	ctx := context.Background()
+	platforms, err := d.ListPlatforms(ctx, "test_repo", "v2")
+	require.NoError(t, err)
+	require.Len(t, platforms, 2)
+	require.Equal(t, "linux/arm64/v8", platforms[0].String())
+	require.Equal(t, "linux/amd64", platforms[1].String())
+	require.True(t, HasPlatform(platforms, "linux/arm64"))
+	require.True(t, HasPlatform(platforms, "linux/arm64/v8"))
+	require.False(t, HasPlatform(platforms, "linux/arm64/v7"))
+
+	q := Quay{
+		BaseURL: "http://example.com",
+		Client:  &http.Client{Transport: newFakeDockerV2Server()},
+	}
+	platforms, err = q.ListPlatforms(ctx, "test_repo", "v1")
+	require.NoError(t, err)
+	require.Len(t, platforms, 1)
+	require.Equal(t, "linux/amd64", platforms[0].String())
+	require.False(t, HasPlatform(platforms, "linux/arm64"))
+
+	// Tokens are only reused if every call goes through the same Docker v2 API
+	require.Same(t, q.dockerV2(), q.dockerV2())
+}
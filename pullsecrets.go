@@ -0,0 +1,348 @@
+package containerimagelisting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ParsePullSecret parses the content of a kubernetes image pull secret.  Both the .dockerconfigjson key of
+// kubernetes.io/dockerconfigjson secrets and the older .dockercfg key of kubernetes.io/dockercfg secrets are accepted.
+func ParsePullSecret(content []byte) (*DockerConfig, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("pull secret does not appear to be JSON: %w", err)
+	}
+	if _, exists := raw["auths"]; exists {
+		return ParseDockerConfig(content)
+	}
+	// .dockercfg is only the auths section, without the wrapping object
+	var auths map[string]DockerConfigAuth
+	if err := json.Unmarshal(content, &auths); err != nil {
+		return nil, fmt.Errorf("pull secret is neither a dockerconfigjson nor a dockercfg: %w", err)
+	}
+	return &DockerConfig{Auths: auths}, nil
+}
+
+// ForPullSecrets factory creates a registry with its finder for every entry in the given kubernetes image pull
+// secrets, which are the decoded .dockerconfigjson (or .dockercfg) values.  Like the kubelet, earlier secrets win
+// when more than one has credentials for the same key, repositories use the entry with the longest matching path, like
+// reg.example.com/team-a over reg.example.com, and hosts may have one * per label, like *.azurecr.io.
+// cfg.Credentials is ignored.
+//
+// Docker Hub is always last, since it matches any repository without a host.
+func ForPullSecrets(secrets [][]byte, cfg RegistryFinderOptionalConfig) ([]RegistryWithFinder, error) {
+	var entries []pullSecretEntry
+	seen := make(map[string]bool)
+	for i, secret := range secrets {
+		parsed, err := ParsePullSecret(secret)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse pull secret %d: %w", i, err)
+		}
+		keys := make([]string, 0, len(parsed.Auths))
+		for key := range parsed.Auths {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			entry := parsePullSecretKey(key, parsed.Auths[key])
+			if seen[entry.host+"/"+entry.path] {
+				continue
+			}
+			seen[entry.host+"/"+entry.path] = true
+			entries = append(entries, entry)
+		}
+	}
+	// RegistryFinder uses the first registry that matches, so longer paths go before shorter ones of the same host,
+	// wildcard hosts go after every exact host, and docker hub goes last
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.order() != b.order() {
+			return a.order() < b.order()
+		}
+		if a.host != b.host {
+			return a.host < b.host
+		}
+		if len(a.path) != len(b.path) {
+			return len(a.path) > len(b.path)
+		}
+		return a.path < b.path
+	})
+
+	ret := make([]RegistryWithFinder, 0, len(entries))
+	for _, entry := range entries {
+		if entry.isWildcard() {
+			entry := entry
+			ret = append(ret, RegistryWithFinder{
+				Registry: &hostRegistries{
+					newRegistry: func(host string) (Registry, error) {
+						r, err := entry.registryFor(host, cfg)
+						return r.Registry, err
+					},
+				},
+				RepositoryLocator: &pullSecretLocator{host: entry.host, path: entry.path, fullRepo: true},
+				Name:              entry.host,
+				// Without a host, there is nothing to send the request to
+				ExcludeFromSearch: true,
+			})
+			continue
+		}
+		r, err := entry.registryFor(entry.host, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if entry.path != "" {
+			r.RepositoryLocator = &pullSecretLocator{host: entry.host, path: entry.path}
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// pullSecretEntry is one auths entry of a pull secret
+type pullSecretEntry struct {
+	// host may have * labels, like *.azurecr.io.  Docker hub is always docker.io.
+	host string
+	// path limits the entry to repositories that start with it, like team-a for reg.example.com/team-a
+	path string
+	auth DockerConfigAuth
+}
+
+// parsePullSecretKey splits a key of the auths section, like https://reg.example.com/team-a/, into host and path
+func parsePullSecretKey(key string, auth DockerConfigAuth) pullSecretEntry {
+	trimmed := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	parts := strings.SplitN(trimmed, "/", 2)
+	ret := pullSecretEntry{
+		host: registryHostOf(parts[0]),
+		auth: auth,
+	}
+	if len(parts) == 2 {
+		ret.path = strings.Trim(parts[1], "/")
+	}
+	// docker login stores docker hub credentials under https://index.docker.io/v1/, which is not a path
+	if ret.host == "docker.io" && ret.path == "v1" {
+		ret.path = ""
+	}
+	return ret
+}
+
+func (e *pullSecretEntry) isWildcard() bool {
+	return strings.Contains(e.host, "*")
+}
+
+// order sorts exact hosts first, then wildcard hosts, then docker hub
+func (e *pullSecretEntry) order() int {
+	switch {
+	case e.host == "docker.io":
+		return 2
+	case e.isWildcard():
+		return 1
+	}
+	return 0
+}
+
+// registryFor creates the registry for host, which is e.host or, for wildcard entries, a host it matches.  The
+// registry only knows the credentials of e.
+func (e *pullSecretEntry) registryFor(host string, cfg RegistryFinderOptionalConfig) (RegistryWithFinder, error) {
+	cfg.Credentials = &DockerConfig{Auths: map[string]DockerConfigAuth{host: e.auth}}
+	switch host {
+	case "docker.io":
+		return ForDockerhub("", "", cfg), nil
+	case "ghcr.io":
+		return ForGHCR("", "", cfg), nil
+	case "quay.io":
+		creds, err := e.auth.credentials()
+		if err != nil {
+			return RegistryWithFinder{}, fmt.Errorf("invalid credentials for %s: %w", host, err)
+		}
+		if creds.Username == quayOAuthTokenUsername {
+			return ForQuay("", cfg), nil
+		}
+		// Robot accounts cannot use the quay API, but can use the Docker v2 API
+	}
+	return forDockerV2Host(host, cfg), nil
+}
+
+// pullSecretLocator matches the repositories a pull secret entry is for, the way the kubelet does: hosts have the
+// same number of labels, each label matches its glob, the ports are the same, and the repository starts with path.
+type pullSecretLocator struct {
+	host string
+	path string
+	// fullRepo returns host/repository, for hostRegistries, which need to know which host was matched
+	fullRepo bool
+}
+
+var _ NamespaceLocator = &pullSecretLocator{}
+
+func (l *pullSecretLocator) RepositoryForURL(url string) string {
+	ref, err := ParseImageReference(url)
+	if err != nil {
+		return ""
+	}
+	return l.RepositoryForReference(*ref)
+}
+
+func (l *pullSecretLocator) RepositoryForReference(ref ImageReference) string {
+	host, repository := ref.Domain, ref.Path
+	if host == "" || isDockerHubHost(host) {
+		host, repository = "docker.io", dockerHubRepository(repository)
+	}
+	if !l.matches(host, repository) {
+		return ""
+	}
+	if l.fullRepo {
+		return host + "/" + repository
+	}
+	return repository
+}
+
+func (l *pullSecretLocator) NamespaceForReference(ref ImageReference) (string, bool) {
+	host := ref.Domain
+	if host == "" || isDockerHubHost(host) {
+		host = "docker.io"
+	}
+	if !l.matches(host, ref.Path) {
+		return "", false
+	}
+	switch {
+	case l.fullRepo && ref.Path == "":
+		return host, true
+	case l.fullRepo:
+		return host + "/" + ref.Path, true
+	}
+	return ref.Path, true
+}
+
+func (l *pullSecretLocator) matches(host string, repository string) bool {
+	// Like the kubelet, the path is a plain string prefix
+	return hostMatchesGlob(l.host, host) && strings.HasPrefix(repository, l.path)
+}
+
+// hostMatchesGlob returns true if host matches pattern, like foo.azurecr.io and *.azurecr.io.  Each * matches one
+// label, and ports must be the same.
+func hostMatchesGlob(pattern string, host string) bool {
+	patternHost, patternPort := splitPort(pattern)
+	host, port := splitPort(host)
+	if patternPort != port {
+		return false
+	}
+	patternLabels := strings.Split(patternHost, ".")
+	labels := strings.Split(host, ".")
+	if len(patternLabels) != len(labels) {
+		return false
+	}
+	for i := range labels {
+		if matched, err := path.Match(patternLabels[i], labels[i]); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func splitPort(host string) (string, string) {
+	if i := strings.LastIndex(host, ":"); i != -1 {
+		return host[:i], host[i+1:]
+	}
+	return host, ""
+}
+
+// hostRegistries is the registry of a wildcard pull secret entry.  Repositories start with their host, like
+// foo.azurecr.io/app, and a registry is created the first time each host is asked for.
+type hostRegistries struct {
+	newRegistry func(host string) (Registry, error)
+	mu          sync.Mutex
+	registries  map[string]Registry
+}
+
+var _ Registry = &hostRegistries{}
+var _ PlatformLister = &hostRegistries{}
+var _ RepositoryLister = &hostRegistries{}
+
+// registryFor splits the host off repository and returns its registry
+func (h *hostRegistries) registryFor(repository string) (Registry, string, error) {
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) == 1 {
+		parts = append(parts, "")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if r, exists := h.registries[parts[0]]; exists {
+		return r, parts[1], nil
+	}
+	r, err := h.newRegistry(parts[0])
+	if err != nil {
+		return nil, "", err
+	}
+	if h.registries == nil {
+		h.registries = make(map[string]Registry)
+	}
+	h.registries[parts[0]] = r
+	return r, parts[1], nil
+}
+
+func (h *hostRegistries) ListTags(ctx context.Context, repository string) ([]Tag, error) {
+	r, repository, err := h.registryFor(repository)
+	if err != nil {
+		return nil, err
+	}
+	return r.ListTags(ctx, repository)
+}
+
+func (h *hostRegistries) ListPlatforms(ctx context.Context, repository string, reference string) ([]PlatformImage, error) {
+	r, repository, err := h.registryFor(repository)
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := r.(PlatformLister)
+	if !ok {
+		return nil, fmt.Errorf("registry for %s cannot list platforms: %w", repository, ErrUnsupported)
+	}
+	return lister.ListPlatforms(ctx, repository, reference)
+}
+
+// ListRepositories lists the repositories of the host namespace starts with.  Like the namespace, the returned
+// repositories start with the host.
+func (h *hostRegistries) ListRepositories(ctx context.Context, namespace string) ([]string, error) {
+	r, scrubbed, err := h.registryFor(namespace)
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := r.(RepositoryLister)
+	if !ok {
+		return nil, fmt.Errorf("registry for %s cannot list repositories: %w", namespace, ErrUnsupported)
+	}
+	repos, err := lister.ListRepositories(ctx, scrubbed)
+	if err != nil {
+		return nil, err
+	}
+	host := strings.SplitN(namespace, "/", 2)[0]
+	ret := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		ret = append(ret, host+"/"+repo)
+	}
+	return ret, nil
+}
+
+// forDockerV2Host creates a Docker v2 registry for host, authenticated with cfg.Credentials
+func forDockerV2Host(host string, cfg RegistryFinderOptionalConfig) RegistryWithFinder {
+	return RegistryWithFinder{
+		Registry: &DockerV2{
+			BaseURL: "https://" + host,
+			Client:  cfg.getClient(),
+			ReAuth: &ScopeReauther{
+				Credentials: cfg.Credentials,
+				Retry:       cfg.Retry,
+			},
+			Retry:    cfg.Retry,
+			Cache:    cfg.Cache,
+			CacheTTL: cfg.CacheTTL,
+		},
+		RepositoryLocator: &MultiURLHostMatcher{
+			ValidDomains: []string{host},
+		},
+	}
+}
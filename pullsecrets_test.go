@@ -0,0 +1,155 @@
+package containerimagelisting
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePullSecret(t *testing.T) {
+	dockerConfigJSON, err := ParsePullSecret([]byte(`{"auths": {"ghcr.io": {"auth": "am9objpkb2U="}}}`))
+	require.NoError(t, err)
+	dockerCfg, err := ParsePullSecret([]byte(`{"ghcr.io": {"auth": "am9objpkb2U="}}`))
+	require.NoError(t, err)
+	require.Equal(t, dockerConfigJSON.Auths, dockerCfg.Auths)
+
+	_, err = ParsePullSecret([]byte(`not json`))
+	require.Error(t, err)
+}
+
+func TestForPullSecrets(t *testing.T) {
+	secrets := [][]byte{
+		[]byte(`{"auths": {
+			"https://index.docker.io/v1/": {"auth": "am9objpkb2U="},
+			"quay.io": {"username": "$oauthtoken", "password": "oauth_token"},
+			"registry.example.com": {"username": "robot", "password": "secret"}
+		}}`),
+		[]byte(`{"ghcr.io": {"auth": "am9objpkb2U="}, "registry.example.com": {"username": "ignored", "password": "ignored"}}`),
+	}
+	registries, err := ForPullSecrets(secrets, RegistryFinderOptionalConfig{})
+	require.NoError(t, err)
+	require.Len(t, registries, 4)
+
+	require.IsType(t, &DockerV2{}, registries[0].Registry)
+	require.Equal(t, "https://ghcr.io", registries[0].Registry.(*DockerV2).BaseURL)
+	require.IsType(t, &Quay{}, registries[1].Registry)
+	require.Equal(t, "https://registry.example.com", registries[2].Registry.(*DockerV2).BaseURL)
+	require.Equal(t, "team/app", registries[2].RepositoryLocator.RepositoryForURL("registry.example.com/team/app"))
+	require.Equal(t, "", registries[2].RepositoryLocator.RepositoryForURL("ghcr.io/team/app"))
+	// Docker hub is last, since it matches anything
+	require.IsType(t, &DockerHubLocator{}, registries[3].RepositoryLocator)
+
+	creds, err := registries[2].Registry.(*DockerV2).ReAuth.Credentials.Credentials(context.Background(), "registry.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "robot", creds.Username)
+
+	// Quay robot accounts use the Docker v2 API
+	registries, err = ForPullSecrets([][]byte{[]byte(`{"auths": {"quay.io": {"auth": "b3JnK3JvYm90OnNlY3JldA=="}}}`)}, RegistryFinderOptionalConfig{})
+	require.NoError(t, err)
+	require.Len(t, registries, 1)
+	require.Equal(t, "https://quay.io", registries[0].Registry.(*DockerV2).BaseURL)
+}
+
+func TestForPullSecrets_BasicAuth(t *testing.T) {
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			u, p, ok := r.BasicAuth()
+			if !ok {
+				header := make(http.Header)
+				header.Set("Www-Authenticate", `Basic realm="https://123456789012.dkr.ecr.us-west-2.amazonaws.com/",service="ecr.amazonaws.com"`)
+				return &http.Response{
+					StatusCode: http.StatusUnauthorized,
+					Header:     header,
+					Request:    r,
+					Body:       ioutil.NopCloser(strings.NewReader("")),
+				}, nil
+			}
+			require.Equal(t, "AWS", u)
+			require.Equal(t, "ecr_password", p)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(strings.NewReader(`{"name": "app", "tags": ["v1"]}`)),
+			}, nil
+		}),
+	}
+	registries, err := ForPullSecrets([][]byte{
+		[]byte(`{"auths": {"123456789012.dkr.ecr.us-west-2.amazonaws.com": {"username": "AWS", "password": "ecr_password"}}}`),
+	}, RegistryFinderOptionalConfig{Client: client})
+	require.NoError(t, err)
+	finder := RegistryFinder{Registries: registries}
+	tags, err := finder.ListTags(context.Background(), "123456789012.dkr.ecr.us-west-2.amazonaws.com/app")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1"}, tagNames(tags))
+}
+
+func TestForPullSecrets_Paths(t *testing.T) {
+	var usedCreds []string
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			u, _, ok := r.BasicAuth()
+			if !ok {
+				header := make(http.Header)
+				header.Set("Www-Authenticate", `Basic realm="registry"`)
+				return &http.Response{
+					StatusCode: http.StatusUnauthorized,
+					Header:     header,
+					Request:    r,
+					Body:       ioutil.NopCloser(strings.NewReader("")),
+				}, nil
+			}
+			usedCreds = append(usedCreds, r.URL.Host+" "+u)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(strings.NewReader(`{"name": "app", "tags": ["v1"]}`)),
+			}, nil
+		}),
+	}
+	registries, err := ForPullSecrets([][]byte{
+		[]byte(`{"auths": {
+			"reg.example.com/team-a": {"username": "team-a", "password": "a"},
+			"https://reg.example.com/team-b/": {"username": "team-b", "password": "b"},
+			"reg.example.com": {"username": "everyone", "password": "e"},
+			"*.azurecr.io": {"username": "azure", "password": "z"}
+		}}`),
+	}, RegistryFinderOptionalConfig{Client: client})
+	require.NoError(t, err)
+	finder := RegistryFinder{Registries: registries}
+	ctx := context.Background()
+	for _, repo := range []string{
+		"reg.example.com/team-b/app",
+		"reg.example.com/team-a/app",
+		"reg.example.com/other/app",
+		"foo.azurecr.io/app",
+		"bar.azurecr.io/team/app:v1",
+	} {
+		tags, err := finder.ListTags(ctx, repo)
+		require.NoError(t, err, repo)
+		require.Equal(t, []string{"v1"}, tagNames(tags))
+	}
+	require.Equal(t, []string{
+		"reg.example.com team-b",
+		"reg.example.com team-a",
+		"reg.example.com everyone",
+		"foo.azurecr.io azure",
+		"bar.azurecr.io azure",
+	}, usedCreds)
+
+	// Each * is a single label
+	for _, repo := range []string{"azurecr.io/app", "a.b.azurecr.io/app"} {
+		_, err = finder.ListTags(ctx, repo)
+		require.ErrorIs(t, err, ErrNoRegistryMatched, repo)
+	}
+}
+
+func TestHostMatchesGlob(t *testing.T) {
+	require.True(t, hostMatchesGlob("*.azurecr.io", "foo.azurecr.io"))
+	require.True(t, hostMatchesGlob("*.*.example.com", "a.b.example.com"))
+	require.True(t, hostMatchesGlob("reg.example.com:5000", "reg.example.com:5000"))
+	require.False(t, hostMatchesGlob("reg.example.com:5000", "reg.example.com"))
+	require.False(t, hostMatchesGlob("*.azurecr.io", "azurecr.io"))
+	require.False(t, hostMatchesGlob("*.azurecr.io", "foo.azurecr.com"))
+}
@@ -4,16 +4,42 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
+	"sync"
+	"time"
 )
 
+// quayOAuthTokenUsername is the special username quay accepts OAuth tokens with, as docker passwords
+const quayOAuthTokenUsername = "$oauthtoken"
+
 // Quay implements quay's API in order to fetch docker image tags
 type Quay struct {
 	Token       string
 	BaseURL     string
 	MaxPageSize int
 	Client      *http.Client
+	// Credentials are looked up for the quay host when Token is empty
+	Credentials CredentialProvider
+	// Retry retries requests that failed with transport errors, 5xx responses or rate limits.  Nil means no retries.
+	Retry *RetryPolicy
+	// Cache remembers tag lists for CacheTTL, and is passed on to the Docker v2 API used for manifests.  Nil means
+	// nothing is cached.
+	Cache Cache
+	// CacheTTL is how long tag lists in Cache are reused before they are listed again.  Defaults to 5 minutes.  After
+	// that, pages are only downloaded again if quay says their ETag or Last-Modified changed.
+	CacheTTL time.Duration
+
+	v2Mu sync.Mutex
+	// v2 is the Docker v2 API of quay, kept so its tokens are reused between calls
+	v2 *DockerV2
+}
+
+func (q *Quay) cacheTTL() time.Duration {
+	if q.CacheTTL == 0 {
+		return 5 * time.Minute
+	}
+	return q.CacheTTL
 }
 
 func (q *Quay) baseURL() string {
@@ -49,73 +75,164 @@ func (q *QuayTag) Tag() string {
 	return q.Name
 }
 
-var _ Tag = &QuayTag{}
-
-func (q *Quay) parseListTagResult(body io.Reader) (tags []QuayTag, additionalPages bool, err error) {
-	// Documented on https://access.redhat.com/documentation/en-us/red_hat_quay/3/html-single/red_hat_quay_api_guide/index#get_api_v1_repository_repository_tag
-	var ltr struct {
-		HasAdditional bool      `json:"has_additional"`
-		Page          int       `json:"page"`
-		Tags          []QuayTag `json:"tags"`
+// Details returns what quay told us about the tag when it was listed
+func (q *QuayTag) Details(_ context.Context) (*TagDetails, error) {
+	ret := TagDetails{
+		Digest:    q.ManifestDigest,
+		Size:      int64(q.Size),
+		MultiArch: q.IsManifestList,
 	}
-	if err := json.NewDecoder(body).Decode(&ltr); err != nil {
-		return nil, false, fmt.Errorf("unable to read from HTTP body: %w", err)
+	if q.StartTs != 0 {
+		ret.Created = time.Unix(int64(q.StartTs), 0)
 	}
-	return ltr.Tags, ltr.HasAdditional, nil
+	return &ret, nil
 }
 
+var _ DetailedTag = &QuayTag{}
+
 // ListTags returns all quay image tags for a repository
 func (q *Quay) ListTags(ctx context.Context, repository string) ([]Tag, error) {
+	tags, err := q.listQuayTags(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
 	var ret []Tag
-	hasMorePages := true
-	for page := 0; hasMorePages; page += 1 {
-		// Create URL
-		url := fmt.Sprintf("%s/api/v1/repository/%s/tag/", q.baseURL(), repository) // NOTE: Fails without trailing slash
+	for i := range tags {
+		ret = append(ret, &tags[i])
+	}
+	return ret, nil
+}
 
-		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+// quayTagPage is one page of quay's tag listing, which Quay also stores in its Cache
+type quayTagPage struct {
+	HasAdditional bool      `json:"has_additional"`
+	Page          int       `json:"page"`
+	Tags          []QuayTag `json:"tags"`
+	// Validators are not part of quay's response.  They are filled in from its headers.
+	Validators cacheValidators `json:"validators"`
+}
+
+// listQuayTags returns the tags of repository from Cache if they are fresh, and lists them otherwise.  Pages of a stale
+// listing are only downloaded again if quay says they changed.
+func (q *Quay) listQuayTags(ctx context.Context, repository string) ([]QuayTag, error) {
+	key := cacheKey("quay", "tags", hostOfURL(q.baseURL()), repository)
+	var cached []quayTagPage
+	if q.Cache != nil {
+		status, err := q.Cache.Get(ctx, key, &cached)
 		if err != nil {
-			return nil, fmt.Errorf("unable to create HTTP request URL: %w", err)
+			cached = nil
 		}
-
-		// Added header if it exists
-		if q.Token != "" {
-			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", q.Token))
+		if err == nil && status == CacheFresh {
+			return quayPageTags(cached), nil
 		}
-
+	}
+	var pages []quayTagPage
+	hasMorePages := true
+	for page := 0; hasMorePages; page += 1 {
 		// Add parameters
-		query := req.URL.Query()
+		query := make(url.Values)
 		query.Add("page", fmt.Sprintf("%d", page))
 		query.Add("onlyActiveTags", "true")
 		query.Add("limit", fmt.Sprintf("%d", q.maxPageSize()))
-		req.URL.RawQuery = query.Encode()
 
-		// Perform request
-		resp, err := q.Client.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("unable to execute HTTP request: %w", err)
-		}
-
-		if resp.StatusCode != http.StatusOK {
-			// TODO: Special error code for "repo does not exist"
-			return nil, fmt.Errorf("status code was %d instead of 200 with status %s", resp.StatusCode, resp.Status)
+		var previous *quayTagPage
+		var sent cacheValidators
+		if page < len(cached) {
+			previous = &cached[page]
+			sent = previous.Validators
 		}
-
-		tags, parsedAdditional, err := q.parseListTagResult(resp.Body)
+		// Documented on https://access.redhat.com/documentation/en-us/red_hat_quay/3/html-single/red_hat_quay_api_guide/index#get_api_v1_repository_repository_tag
+		var ltr quayTagPage
+		validators, notModified, err := q.getJSONIfModified(ctx, fmt.Sprintf("/api/v1/repository/%s/tag/", repository), query, sent, &ltr) // NOTE: Fails without trailing slash
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse tag results from body: %w", err)
+			return nil, err
 		}
-		hasMorePages = parsedAdditional // Note: be careful with shadowing if you move this into the := parseListTagResult line above
-		for _, t := range tags {
-			t := t
-			ret = append(ret, &t)
+		if notModified && previous != nil {
+			ltr = *previous
 		}
+		ltr.Validators = validators
+		pages = append(pages, ltr)
+		hasMorePages = ltr.HasAdditional
+	}
+	if q.Cache != nil {
+		_ = q.Cache.Set(ctx, key, pages, q.cacheTTL())
+	}
+	return quayPageTags(pages), nil
+}
 
-		// Closing request before we call next recursive function
-		err = resp.Body.Close()
-		if err != nil {
-			return nil, fmt.Errorf("unable to close response body: %w", err)
-		}
+func quayPageTags(pages []quayTagPage) []QuayTag {
+	var ret []QuayTag
+	for _, page := range pages {
+		ret = append(ret, page.Tags...)
 	}
+	return ret
+}
 
-	return ret, nil
+// token returns Token, or the OAuth token in Credentials for the quay host.  The quay API only takes OAuth tokens, so
+// robot account passwords are left to the Docker v2 API.
+func (q *Quay) token(ctx context.Context) (string, error) {
+	if q.Token != "" || q.Credentials == nil {
+		return q.Token, nil
+	}
+	host := hostOfURL(q.baseURL())
+	creds, err := q.Credentials.Credentials(ctx, host)
+	if err != nil {
+		return "", fmt.Errorf("unable to find credentials for %s: %w", host, err)
+	}
+	if creds == nil {
+		return "", nil
+	}
+	if creds.RegistryToken != "" {
+		return creds.RegistryToken, nil
+	}
+	if creds.Username == quayOAuthTokenUsername {
+		return creds.Password, nil
+	}
+	return "", nil
+}
+
+// getJSON issues a GET against the quay API and decodes the JSON response into into
+func (q *Quay) getJSON(ctx context.Context, path string, query url.Values, into interface{}) error {
+	_, _, err := q.getJSONIfModified(ctx, path, query, cacheValidators{}, into)
+	return err
+}
+
+// getJSONIfModified is getJSON that sends validators from an earlier response.  If quay answers 304 Not Modified,
+// nothing is decoded and notModified is true.  It returns the validators of the response.
+func (q *Quay) getJSONIfModified(ctx context.Context, path string, query url.Values, validators cacheValidators, into interface{}) (cacheValidators, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", q.baseURL()+path, nil)
+	if err != nil {
+		return cacheValidators{}, false, fmt.Errorf("unable to create HTTP request URL: %w", err)
+	}
+	req.URL.RawQuery = query.Encode()
+	validators.setHeaders(req.Header)
+
+	// Added header if it exists
+	token, err := q.token(ctx)
+	if err != nil {
+		return cacheValidators{}, false, err
+	}
+	if token != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+
+	// Perform request
+	resp, err := q.Retry.do(q.Client, req)
+	if err != nil {
+		return cacheValidators{}, false, fmt.Errorf("unable to execute HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && validators != (cacheValidators{}) {
+		return notModifiedValidators(resp.Header, validators), true, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Quay returns a 404 for repositories that do not exist, which StatusError reports as ErrRepositoryNotFound
+		return cacheValidators{}, false, newStatusError(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(into); err != nil {
+		return cacheValidators{}, false, fmt.Errorf("unable to read from HTTP body: %w", err)
+	}
+	return validatorsOf(resp.Header), false, nil
 }
@@ -2,10 +2,13 @@ package containerimagelisting
 
 import (
 	"context"
+	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -31,3 +34,129 @@ func TestQuay_ListTags(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, []Tag{&QuayTag{Name: "test_name"}}, tags)
 }
+
+func TestQuay_ListTagsNotFound(t *testing.T) {
+	q := Quay{
+		Client: &http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusNotFound,
+					Status:     "404 Not Found",
+					Body:       ioutil.NopCloser(strings.NewReader(`{"error_message": "Not Found"}`)),
+				}, nil
+			}),
+		},
+	}
+	_, err := q.ListTags(context.Background(), "missing")
+	require.True(t, errors.Is(err, ErrRepositoryNotFound))
+}
+
+// closeTracker is a response body that remembers if it was closed
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestQuay_ListTagsClosesBody(t *testing.T) {
+	var bodies []*closeTracker
+	q := Quay{
+		Client: &http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				body := &closeTracker{Reader: strings.NewReader(`not json`)}
+				bodies = append(bodies, body)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       body,
+				}, nil
+			}),
+		},
+	}
+	_, err := q.ListTags(context.Background(), "broken")
+	require.Error(t, err)
+	require.Len(t, bodies, 1)
+	require.True(t, bodies[0].closed)
+}
+
+func TestQuay_ListTagsCache(t *testing.T) {
+	requests := 0
+	cache := &DiskCache{Dir: t.TempDir()}
+	newQuay := func() *Quay {
+		return &Quay{
+			Client: &http.Client{
+				Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					requests++
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       ioutil.NopCloser(strings.NewReader(`{"tags": [{"name": "v1", "manifest_digest": "sha256:abc", "start_ts": 1627776000}]}`)),
+					}, nil
+				}),
+			},
+			Cache: cache,
+		}
+	}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		tags, err := newQuay().ListTags(ctx, "cresta/app")
+		require.NoError(t, err)
+		require.Equal(t, []string{"v1"}, tagNames(tags))
+		details, err := DetailsForTag(ctx, tags[0])
+		require.NoError(t, err)
+		require.Equal(t, "sha256:abc", details.Digest)
+	}
+	require.Equal(t, 1, requests)
+}
+
+func TestQuay_ConditionalListTags(t *testing.T) {
+	body := `{"tags": [{"name": "v1"}]}`
+	lastModified := "Sun, 01 Aug 2021 00:00:00 GMT"
+	var notModified, downloaded int
+	now := time.Now()
+	cache := &DiskCache{
+		Dir: t.TempDir(),
+		now: func() time.Time { return now },
+	}
+	q := Quay{
+		Cache: cache,
+		Client: &http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				header := make(http.Header)
+				if r.Header.Get("If-Modified-Since") == lastModified {
+					notModified++
+					return &http.Response{
+						StatusCode: http.StatusNotModified,
+						Header:     header,
+						Body:       ioutil.NopCloser(strings.NewReader("")),
+					}, nil
+				}
+				downloaded++
+				header.Set("Last-Modified", lastModified)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     header,
+					Body:       ioutil.NopCloser(strings.NewReader(body)),
+				}, nil
+			}),
+		},
+	}
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		tags, err := q.ListTags(ctx, "cresta/app")
+		require.NoError(t, err)
+		require.Equal(t, []string{"v1"}, tagNames(tags))
+		now = now.Add(time.Hour)
+	}
+	require.Equal(t, 1, downloaded)
+	require.Equal(t, 2, notModified)
+
+	body = `{"tags": [{"name": "v1"}, {"name": "v2"}]}`
+	lastModified = "Mon, 02 Aug 2021 00:00:00 GMT"
+	tags, err := q.ListTags(ctx, "cresta/app")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1", "v2"}, tagNames(tags))
+	require.Equal(t, 2, downloaded)
+}
@@ -0,0 +1,113 @@
+package containerimagelisting
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ImageReference is a parsed image reference, like what we would see on "docker pull X".  For example,
+// localhost:5000/team/app:1.2@sha256:abc... has the Domain localhost:5000, the Path team/app, the Tag 1.2 and the
+// Digest sha256:abc...
+type ImageReference struct {
+	// Domain is the registry host, with its port if it has one.  It is empty when the reference does not name a
+	// registry, like "redis" or "cresta/app".
+	Domain string
+	Path   string
+	Tag    string
+	Digest string
+}
+
+// Grammar documented at https://github.com/distribution/distribution/blob/main/reference/reference.go
+var (
+	referenceDomainRegex = regexp.MustCompile(`^(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?$`)
+	referencePathRegex   = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*)*$`)
+	referenceTagRegex    = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	referenceDigestRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+)
+
+// maxReferenceNameLength is the longest a name (domain and path) may be
+const maxReferenceNameLength = 255
+
+// ParseImageReference parses an image reference like redis, ghcr.io/a/b:1.2, localhost:5000/x or redis@sha256:...
+// Errors match ErrInvalidReference with errors.Is.
+func ParseImageReference(s string) (*ImageReference, error) {
+	var ret ImageReference
+	name := s
+	if idx := strings.Index(name, "@"); idx != -1 {
+		ret.Digest = name[idx+1:]
+		name = name[:idx]
+		if !referenceDigestRegex.MatchString(ret.Digest) {
+			return nil, fmt.Errorf("%w: invalid digest %q in %q", ErrInvalidReference, ret.Digest, s)
+		}
+	}
+	// A tag is after the last colon, as long as that colon is not part of the domain, like localhost:5000/x
+	if idx := strings.LastIndex(name, ":"); idx != -1 && !strings.Contains(name[idx+1:], "/") {
+		ret.Tag = name[idx+1:]
+		name = name[:idx]
+		if !referenceTagRegex.MatchString(ret.Tag) {
+			return nil, fmt.Errorf("%w: invalid tag %q in %q", ErrInvalidReference, ret.Tag, s)
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("%w: missing repository in %q", ErrInvalidReference, s)
+	}
+	if len(name) > maxReferenceNameLength {
+		return nil, fmt.Errorf("%w: repository name longer than %d characters in %q", ErrInvalidReference, maxReferenceNameLength, s)
+	}
+	ret.Domain, ret.Path = splitReferenceDomain(name)
+	if ret.Domain != "" && !referenceDomainRegex.MatchString(ret.Domain) {
+		return nil, fmt.Errorf("%w: invalid registry %q in %q", ErrInvalidReference, ret.Domain, s)
+	}
+	if !referencePathRegex.MatchString(ret.Path) {
+		return nil, fmt.Errorf("%w: invalid repository %q in %q", ErrInvalidReference, ret.Path, s)
+	}
+	return &ret, nil
+}
+
+// parseNamespace parses a namespace for RegistryFinder.ListRepositories, like quay.io/cresta or cresta.  A bare registry
+// host, like quay.io or localhost:5000/, is the whole registry, and parses to a reference with an empty Path.
+func parseNamespace(namespace string) (*ImageReference, error) {
+	trimmed := strings.TrimSuffix(namespace, "/")
+	if !strings.Contains(trimmed, "/") && (strings.ContainsAny(trimmed, ".:") || trimmed == "localhost") {
+		if !referenceDomainRegex.MatchString(trimmed) {
+			return nil, fmt.Errorf("%w: invalid registry %q in %q", ErrInvalidReference, trimmed, namespace)
+		}
+		return &ImageReference{Domain: trimmed}, nil
+	}
+	return ParseImageReference(trimmed)
+}
+
+// splitReferenceDomain splits off the first component of name if it is a registry host.  Like docker, the first
+// component is a host if it has a '.' or a ':', is localhost, or has upper case letters (which paths cannot).
+func splitReferenceDomain(name string) (string, string) {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 1 {
+		return "", name
+	}
+	first := parts[0]
+	if strings.ContainsAny(first, ".:") || first == "localhost" || strings.ToLower(first) != first {
+		return first, parts[1]
+	}
+	return "", name
+}
+
+// Name returns the reference without its tag or digest, like ghcr.io/a/b
+func (r ImageReference) Name() string {
+	if r.Domain == "" {
+		return r.Path
+	}
+	return r.Domain + "/" + r.Path
+}
+
+// String returns the reference in the form it was parsed from
+func (r ImageReference) String() string {
+	ret := r.Name()
+	if r.Tag != "" {
+		ret += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		ret += "@" + r.Digest
+	}
+	return ret
+}
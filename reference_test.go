@@ -0,0 +1,85 @@
+package containerimagelisting
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImageReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	testFunc := func(given string, expected ImageReference) func(t *testing.T) {
+		return func(t *testing.T) {
+			ref, err := ParseImageReference(given)
+			require.NoError(t, err)
+			require.Equal(t, expected, *ref)
+			require.Equal(t, given, ref.String())
+		}
+	}
+	t.Run("name_only", testFunc("redis", ImageReference{Path: "redis"}))
+	t.Run("namespace", testFunc("cresta/app", ImageReference{Path: "cresta/app"}))
+	t.Run("domain_and_tag", testFunc("ghcr.io/a/b:1.2", ImageReference{Domain: "ghcr.io", Path: "a/b", Tag: "1.2"}))
+	t.Run("digest", testFunc("redis@"+digest, ImageReference{Path: "redis", Digest: digest}))
+	t.Run("tag_and_digest", testFunc("quay.io/a/b:v1@"+digest, ImageReference{Domain: "quay.io", Path: "a/b", Tag: "v1", Digest: digest}))
+	t.Run("port", testFunc("localhost:5000/x", ImageReference{Domain: "localhost:5000", Path: "x"}))
+	t.Run("port_and_tag", testFunc("localhost:5000/x:latest", ImageReference{Domain: "localhost:5000", Path: "x", Tag: "latest"}))
+	t.Run("localhost", testFunc("localhost/x", ImageReference{Domain: "localhost", Path: "x"}))
+	t.Run("library", testFunc("docker.io/library/redis", ImageReference{Domain: "docker.io", Path: "library/redis"}))
+	t.Run("separators", testFunc("example.com/a__b/c-d.e_f", ImageReference{Domain: "example.com", Path: "a__b/c-d.e_f"}))
+
+	invalid := []string{
+		"",
+		"Redis",
+		"ghcr.io/A/b",
+		"redis:",
+		"redis:-bad",
+		"redis@sha256:abc",
+		"ghcr.io/a//b",
+		"ghcr.io/a/b/",
+		"-bad.example.com/a",
+		"example.com/" + strings.Repeat("a", 256),
+	}
+	for _, s := range invalid {
+		_, err := ParseImageReference(s)
+		require.ErrorIs(t, err, ErrInvalidReference, s)
+	}
+}
+
+func TestRegistryFinder_ParsesReferences(t *testing.T) {
+	var listed []string
+	registry := func(name string) Registry {
+		return &registryFunc{listTags: func(ctx context.Context, repository string) ([]Tag, error) {
+			listed = append(listed, name+" "+repository)
+			return nil, nil
+		}}
+	}
+	finder := RegistryFinder{
+		Registries: []RegistryWithFinder{
+			{Registry: registry("ghcr"), RepositoryLocator: &MultiURLHostMatcher{ValidDomains: []string{"ghcr.io"}}},
+			{Registry: registry("local"), RepositoryLocator: &MultiURLHostMatcher{ValidDomains: []string{"localhost:5000"}}},
+			{Registry: registry("dockerhub"), RepositoryLocator: &DockerHubLocator{MultiURLHostMatcher: MultiURLHostMatcher{ValidDomains: []string{"docker.io"}}}},
+		},
+	}
+	ctx := context.Background()
+	for _, repo := range []string{"ghcr.io/a/b:1.2", "localhost:5000/x", "redis@sha256:" + strings.Repeat("ab", 32), "docker.io/cresta/app:v1"} {
+		_, err := finder.ListTags(ctx, repo)
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{"ghcr a/b", "local x", "dockerhub library/redis", "dockerhub cresta/app"}, listed)
+
+	_, err := finder.ListTags(ctx, "ghcr.io/a/b:")
+	require.ErrorIs(t, err, ErrInvalidReference)
+	_, err = finder.ListTags(ctx, "example.com/a")
+	require.ErrorIs(t, err, ErrNoRegistryMatched)
+}
+
+// registryFunc is a Registry backed by a function, for tests that only care which repository was asked for
+type registryFunc struct {
+	listTags func(ctx context.Context, repository string) ([]Tag, error)
+}
+
+func (r *registryFunc) ListTags(ctx context.Context, repository string) ([]Tag, error) {
+	return r.listTags(ctx, repository)
+}
@@ -2,19 +2,31 @@ package containerimagelisting
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"regexp"
+	"strings"
+	"time"
 )
 
 // RegistryWithFinder is used by RegistryFinder to match docker images with the registry that should fetch it
 type RegistryWithFinder struct {
 	Registry          Registry
 	RepositoryLocator RepositoryLocator
+	// Name describes the registry in ListTagsResult.Source and SearchResult.Source.  Defaults to the registry host.
+	Name string
+	// ExcludeFromSearch stops RegistryFinder.Search from asking this registry for names without a registry host
+	ExcludeFromSearch bool
 }
 
 // RegistryFinder helps aggregate different registries with a way to match images to the registry
 type RegistryFinder struct {
 	Registries []RegistryWithFinder
+	// Mirrors are tried, in order, before the registry that matches a repository's host
+	Mirrors []Mirror
+	// ConcurrencyPerHost is how many requests ListTagsBulk sends to one registry host at a time.  Defaults to 4.
+	// Negative values send one at a time.
+	ConcurrencyPerHost int
 }
 
 var _ Registry = &RegistryFinder{}
@@ -22,19 +34,109 @@ var _ Registry = &RegistryFinder{}
 // ListTags for a repository using many backends.
 // Should take a repository like what we would see on "docker pull X"
 func (r *RegistryFinder) ListTags(ctx context.Context, repository string) ([]Tag, error) {
+	ret, err := r.ListTagsWithSource(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+	return ret.Tags, nil
+}
+
+// ListPlatforms returns the platforms of an image using the registry that matches the repository.  The registry must
+// implement PlatformLister.  If reference is empty, the digest or tag in repository is used, like ghcr.io/a/b:1.2,
+// defaulting to latest.
+func (r *RegistryFinder) ListPlatforms(ctx context.Context, repository string, reference string) ([]PlatformImage, error) {
+	ref, err := ParseImageReference(repository)
+	if err != nil {
+		return nil, err
+	}
+	if reference == "" {
+		reference = defaultReference(ref)
+	}
+	for _, registry := range r.Registries {
+		scrubbedURL := locateRepository(registry.RepositoryLocator, ref)
+		if scrubbedURL == "" {
+			continue
+		}
+		lister, ok := registry.Registry.(PlatformLister)
+		if !ok {
+			return nil, fmt.Errorf("registry for %s cannot list platforms: %w", repository, ErrUnsupported)
+		}
+		return lister.ListPlatforms(ctx, scrubbedURL, reference)
+	}
+	return nil, fmt.Errorf("unable to find registry for %s: %w", repository, ErrNoRegistryMatched)
+}
+
+// ListRepositories lists the repositories inside a namespace, using the registry that matches the namespace.  The
+// namespace is written like an image, for example "quay.io/cresta" or "cresta" for docker hub, or is a bare registry
+// host like "quay.io" to list every repository of that registry.  Returned repositories are written the same way, like
+// "quay.io/cresta/foo".  The registry must implement RepositoryLister.
+func (r *RegistryFinder) ListRepositories(ctx context.Context, namespace string) ([]string, error) {
+	ref, err := parseNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
 	for _, registry := range r.Registries {
-		scrubbedURL := registry.RepositoryLocator.RepositoryForURL(repository)
-		if scrubbedURL != "" {
-			return registry.Registry.ListTags(ctx, scrubbedURL)
+		scrubbedNamespace, ok := locateNamespace(registry.RepositoryLocator, ref)
+		if !ok {
+			continue
+		}
+		lister, ok := registry.Registry.(RepositoryLister)
+		if !ok {
+			return nil, fmt.Errorf("registry for %s cannot list repositories: %w", namespace, ErrUnsupported)
+		}
+		repos, err := lister.ListRepositories(ctx, scrubbedNamespace)
+		if err != nil {
+			return nil, err
 		}
+		prefix := namespacePrefix(ref, scrubbedNamespace)
+		ret := make([]string, 0, len(repos))
+		for _, repo := range repos {
+			ret = append(ret, prefix+repo)
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("unable to find registry for %s: %w", namespace, ErrNoRegistryMatched)
+}
+
+// namespacePrefix is whatever the locator removed from ref to get namespace, like the "quay.io/" in "quay.io/cresta",
+// which is put back in front of the repositories the registry returns
+func namespacePrefix(ref *ImageReference, namespace string) string {
+	name := ref.Name()
+	if ref.Path == "" {
+		name = ref.Domain
+	}
+	prefix := strings.TrimSuffix(name, namespace)
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// defaultReference is the digest or tag of ref, or latest like docker pull uses
+func defaultReference(ref *ImageReference) string {
+	if ref.Digest != "" {
+		return ref.Digest
 	}
-	// TODO: Do we want a "not found" error code of some kind?
-	return nil, nil
+	if ref.Tag != "" {
+		return ref.Tag
+	}
+	return "latest"
 }
 
 // RegistryFinderOptionalConfig configures the helper functions for registries
 type RegistryFinderOptionalConfig struct {
 	Client *http.Client
+	// Credentials are used when the username, password or token passed to a factory is empty, for example a
+	// DockerConfig loaded with LoadDefaultDockerConfig
+	Credentials CredentialProvider
+	// Retry is used by the Docker v2 and quay registries to retry requests that failed with transport errors, 5xx
+	// responses or rate limits.  Nil means no retries.  The AWS SDK retries native ECR requests on its own.
+	Retry *RetryPolicy
+	// Cache is used by the Docker v2 and quay registries to reuse tag lists for CacheTTL, and manifests fetched by
+	// digest forever, for example a DiskCache shared by CI runs.  Nil means nothing is cached.
+	Cache Cache
+	// CacheTTL is how long tag lists in Cache are reused.  Defaults to 5 minutes.
+	CacheTTL time.Duration
 }
 
 func (r *RegistryFinderOptionalConfig) getClient() *http.Client {
@@ -51,9 +153,14 @@ func ForGHCR(ghcrUsername string, ghcrPassword string, cfg RegistryFinderOptiona
 			BaseURL: "https://ghcr.io",
 			Client:  cfg.getClient(),
 			ReAuth: &ScopeReauther{
-				Username: ghcrUsername,
-				Password: ghcrPassword,
+				Username:    ghcrUsername,
+				Password:    ghcrPassword,
+				Credentials: cfg.Credentials,
+				Retry:       cfg.Retry,
 			},
+			Retry:    cfg.Retry,
+			Cache:    cfg.Cache,
+			CacheTTL: cfg.CacheTTL,
 		},
 		RepositoryLocator: &MultiURLHostMatcher{
 			ValidDomains: []string{"ghcr.io"},
@@ -68,9 +175,14 @@ func ForDockerhub(dockerhubUsername string, dockerhubPassword string, cfg Regist
 			BaseURL: "https://registry-1.docker.io/",
 			Client:  cfg.getClient(),
 			ReAuth: &ScopeReauther{
-				Username: dockerhubUsername,
-				Password: dockerhubPassword,
+				Username:    dockerhubUsername,
+				Password:    dockerhubPassword,
+				Credentials: cfg.Credentials,
+				Retry:       cfg.Retry,
 			},
+			Retry:    cfg.Retry,
+			Cache:    cfg.Cache,
+			CacheTTL: cfg.CacheTTL,
 		},
 		RepositoryLocator: &DockerHubLocator{
 			MultiURLHostMatcher: MultiURLHostMatcher{
@@ -84,8 +196,12 @@ func ForDockerhub(dockerhubUsername string, dockerhubPassword string, cfg Regist
 func ForQuay(quayToken string, cfg RegistryFinderOptionalConfig) RegistryWithFinder {
 	return RegistryWithFinder{
 		Registry: &Quay{
-			Token:  quayToken,
-			Client: cfg.getClient(),
+			Token:       quayToken,
+			Client:      cfg.getClient(),
+			Credentials: cfg.Credentials,
+			Retry:       cfg.Retry,
+			Cache:       cfg.Cache,
+			CacheTTL:    cfg.CacheTTL,
 		},
 		RepositoryLocator: &MultiURLHostMatcher{
 			ValidDomains: []string{"quay.io"},
@@ -93,8 +209,18 @@ func ForQuay(quayToken string, cfg RegistryFinderOptionalConfig) RegistryWithFin
 	}
 }
 
-// ForECR factory helps create a ECR registry with its finder
+// ForECR factory helps create a ECR registry with its finder.  It is for a single registry: when ecrBaseURL is an ECR
+// host, like https://123456789012.dkr.ecr.us-west-2.amazonaws.com, only that host is matched.  Otherwise every ECR host
+// is matched and sent to ecrBaseURL.  Use ForECRHosts for more than one account or region.
 func ForECR(ecrClient ECRClient, ecrBaseURL string, cfg RegistryFinderOptionalConfig) RegistryWithFinder {
+	locator := &MultiURLHostMatcher{
+		ValidRegex: []*regexp.Regexp{regexp.MustCompile(`dkr\.ecr\..*\.amazonaws\.com`)},
+	}
+	if host := hostOfURL(ecrBaseURL); isECRHost(host) {
+		locator = &MultiURLHostMatcher{
+			ValidDomains: []string{host},
+		}
+	}
 	return RegistryWithFinder{
 		Registry: &DockerV2{
 			BaseURL: ecrBaseURL,
@@ -103,9 +229,86 @@ func ForECR(ecrClient ECRClient, ecrBaseURL string, cfg RegistryFinderOptionalCo
 				ECR:            ecrClient,
 				AuthBufferTime: 0,
 			},
+			Retry:    cfg.Retry,
+			Cache:    cfg.Cache,
+			CacheTTL: cfg.CacheTTL,
+		},
+		RepositoryLocator: locator,
+	}
+}
+
+// ForECRHosts factory helps create one ECR registry per base URL, each matching only its own host.  Every registry
+// shares a single ECRAuthWrapper, which fetches a token per AWS account and region.  ecrForRegion may be nil if every
+// registry is in the region of ecrClient.
+func ForECRHosts(ecrClient ECRClient, ecrForRegion func(region string) (ECRClient, error), ecrBaseURLs []string, cfg RegistryFinderOptionalConfig) []RegistryWithFinder {
+	authWrapper := &ECRAuthWrapper{
+		ECR:          ecrClient,
+		ECRForRegion: ecrForRegion,
+	}
+	ret := make([]RegistryWithFinder, 0, len(ecrBaseURLs))
+	for _, baseURL := range ecrBaseURLs {
+		ret = append(ret, RegistryWithFinder{
+			Registry: &DockerV2{
+				BaseURL:        baseURL,
+				Client:         cfg.getClient(),
+				RequestWrapper: authWrapper,
+				Retry:          cfg.Retry,
+				Cache:          cfg.Cache,
+				CacheTTL:       cfg.CacheTTL,
+			},
+			RepositoryLocator: &MultiURLHostMatcher{
+				ValidDomains: []string{hostOfURL(baseURL)},
+			},
+		})
+	}
+	return ret
+}
+
+// hostOfURL returns the host of a base URL like https://ghcr.io/
+func hostOfURL(baseURL string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(strings.TrimSuffix(baseURL, "/"), "https://"), "http://")
+}
+
+// ForNativeECR factory helps create an ECR registry that uses ECR's own APIs, so tags know when they were pushed and
+// scanned.  ecrBaseURL must be an ECR host, like https://123456789012.dkr.ecr.us-west-2.amazonaws.com, and ecrClient
+// must be for its region.  Only that host is matched, and its registry ID is sent with every request.  Use
+// ForNativeECRHosts for more than one ECR registry.
+func ForNativeECR(ecrClient ECRAPIClient, ecrBaseURL string) (RegistryWithFinder, error) {
+	host := hostOfURL(ecrBaseURL)
+	registryID, _, ok := parseECRHost(host)
+	if !ok {
+		return RegistryWithFinder{}, fmt.Errorf("%q is not an ECR registry host", ecrBaseURL)
+	}
+	return RegistryWithFinder{
+		Registry: &ECR{
+			ECR:        ecrClient,
+			RegistryID: registryID,
 		},
 		RepositoryLocator: &MultiURLHostMatcher{
-			ValidRegex: []*regexp.Regexp{regexp.MustCompile(`dkr\.ecr\..*\.amazonaws\.com`)},
+			ValidDomains: []string{host},
 		},
+	}, nil
+}
+
+// ForNativeECRHosts factory helps create one native ECR registry per base URL, like ForNativeECR does for one.
+// ecrForRegion returns the client for the region of each host.  It may be nil if every registry is in the region of
+// ecrClient.
+func ForNativeECRHosts(ecrClient ECRAPIClient, ecrForRegion func(region string) (ECRAPIClient, error), ecrBaseURLs []string) ([]RegistryWithFinder, error) {
+	ret := make([]RegistryWithFinder, 0, len(ecrBaseURLs))
+	for _, baseURL := range ecrBaseURLs {
+		client := ecrClient
+		if _, region, ok := parseECRHost(hostOfURL(baseURL)); ok && ecrForRegion != nil {
+			var err error
+			client, err = ecrForRegion(region)
+			if err != nil {
+				return nil, fmt.Errorf("unable to create ECR client for region %s: %w", region, err)
+			}
+		}
+		registry, err := ForNativeECR(client, baseURL)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, registry)
 	}
+	return ret, nil
 }
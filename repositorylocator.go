@@ -11,6 +11,41 @@ type RepositoryLocator interface {
 	RepositoryForURL(url string) string
 }
 
+// ReferenceLocator is a RepositoryLocator that can work on an already parsed image reference.  RegistryFinder prefers
+// it over RepositoryForURL, so the tag and digest never end up in the repository.
+type ReferenceLocator interface {
+	RepositoryLocator
+	RepositoryForReference(ref ImageReference) string
+}
+
+// locateRepository asks locator for the repository of ref, using RepositoryForReference if locator supports it
+func locateRepository(locator RepositoryLocator, ref *ImageReference) string {
+	if rl, ok := locator.(ReferenceLocator); ok {
+		return rl.RepositoryForReference(*ref)
+	}
+	return locator.RepositoryForURL(ref.Name())
+}
+
+// NamespaceLocator is a RepositoryLocator that can also locate namespaces for RegistryFinder.ListRepositories.  Unlike
+// repositories, a namespace may be empty, when ref names a whole registry like quay.io, so ok says if ref matched.
+type NamespaceLocator interface {
+	RepositoryLocator
+	NamespaceForReference(ref ImageReference) (namespace string, ok bool)
+}
+
+// locateNamespace asks locator for the namespace of ref.  Locators that are not NamespaceLocators can only locate
+// namespaces inside a registry, the same way they locate repositories.
+func locateNamespace(locator RepositoryLocator, ref *ImageReference) (string, bool) {
+	if nl, ok := locator.(NamespaceLocator); ok {
+		return nl.NamespaceForReference(*ref)
+	}
+	if ref.Path == "" {
+		return "", false
+	}
+	namespace := locateRepository(locator, ref)
+	return namespace, namespace != ""
+}
+
 // URLMatchFunc is a function wrapper for RepositoryLocator
 type URLMatchFunc func(url string) string
 
@@ -46,6 +81,52 @@ func (m *MultiURLHostMatcher) matches(firstDomain string) bool {
 }
 
 func (m *MultiURLHostMatcher) RepositoryForURL(repo string) string {
+	ref, err := ParseImageReference(repo)
+	if err != nil {
+		return ""
+	}
+	return m.RepositoryForReference(*ref)
+}
+
+func (m *MultiURLHostMatcher) RepositoryForReference(ref ImageReference) string {
+	if ref.Domain == "" {
+		// Matchers may name registries without a '.', like "hello/world" for the domain "hello"
+		return m.repositoryForName(ref.Path)
+	}
+	if !m.matches(ref.Domain) {
+		return ""
+	}
+	if m.ReturnFullRepo {
+		return ref.Name()
+	}
+	return ref.Path
+}
+
+func (m *MultiURLHostMatcher) NamespaceForReference(ref ImageReference) (string, bool) {
+	if ref.Domain == "" {
+		// Like repositories, "hello/world" may name the namespace "world" of the registry "hello", and "hello" the whole
+		// registry
+		parts := strings.SplitN(ref.Path, "/", 2)
+		if !m.matches(parts[0]) {
+			return "", false
+		}
+		ref = ImageReference{Domain: parts[0]}
+		if len(parts) == 2 {
+			ref.Path = parts[1]
+		}
+	} else if !m.matches(ref.Domain) {
+		return "", false
+	}
+	switch {
+	case m.ReturnFullRepo && ref.Path == "":
+		return ref.Domain, true
+	case m.ReturnFullRepo:
+		return ref.Name(), true
+	}
+	return ref.Path, true
+}
+
+func (m *MultiURLHostMatcher) repositoryForName(repo string) string {
 	parts := strings.SplitN(repo, "/", 2)
 	if len(parts) == 1 {
 		return ""
@@ -61,22 +142,54 @@ func (m *MultiURLHostMatcher) RepositoryForURL(repo string) string {
 }
 
 var _ RepositoryLocator = URLMatchFunc(nil)
+var _ ReferenceLocator = &MultiURLHostMatcher{}
+var _ NamespaceLocator = &MultiURLHostMatcher{}
 
-// DockerHubLocator helps match dockerhub repositories since it assumes the first part of the / split path is dockerhub
-// if it does not contain a '.'
+// DockerHubLocator helps match dockerhub repositories since it assumes references that do not name a registry host,
+// like cresta/app, are on dockerhub.  The docker hub hosts docker.io, index.docker.io and registry-1.docker.io always
+// match, and official images like redis become library/redis, which is what the docker hub API expects.
 type DockerHubLocator struct {
 	MultiURLHostMatcher MultiURLHostMatcher
 }
 
+var _ ReferenceLocator = &DockerHubLocator{}
+var _ NamespaceLocator = &DockerHubLocator{}
+
 func (m *DockerHubLocator) RepositoryForURL(repo string) string {
+	ref, err := ParseImageReference(repo)
+	if err != nil {
+		return ""
+	}
+	return m.RepositoryForReference(*ref)
+}
+
+func (m *DockerHubLocator) RepositoryForReference(ref ImageReference) string {
 	// docker pull cresta/blarg    <--- dockerhub
 	// docker pull ghcr.io/a/b     <--- no docker hub
 
 	// If you get ghcr.io/a/b  ->>>> You want to use the repo "a/b" not the repo "ghcr.io/a/b"
+	if ref.Domain == "" || isDockerHubHost(ref.Domain) {
+		return dockerHubRepository(ref.Path)
+	}
+	if m.MultiURLHostMatcher.ReturnFullRepo {
+		return m.MultiURLHostMatcher.RepositoryForReference(ref)
+	}
+	return dockerHubRepository(m.MultiURLHostMatcher.RepositoryForReference(ref))
+}
+
+func (m *DockerHubLocator) NamespaceForReference(ref ImageReference) (string, bool) {
+	// Unlike repositories, namespaces are not official images, so "cresta" stays "cresta" instead of becoming
+	// library/cresta
+	if ref.Domain == "" || isDockerHubHost(ref.Domain) {
+		return ref.Path, true
+	}
+	return m.MultiURLHostMatcher.NamespaceForReference(ref)
+}
 
-	parts := strings.Split(repo, "/")
-	if !strings.Contains(parts[0], ".") {
-		return repo
+// dockerHubRepository adds the library/ namespace that official images, like redis, live in
+func dockerHubRepository(path string) string {
+	if path == "" || strings.Contains(path, "/") {
+		return path
 	}
-	return m.MultiURLHostMatcher.RepositoryForURL(repo)
+	return "library/" + path
 }
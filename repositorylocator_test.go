@@ -25,7 +25,23 @@ func TestDockerHubLocator(t *testing.T) {
 			require.Equal(t, expected, given.RepositoryForURL(repo))
 		}
 	}
-	t.Run("empty", testFunc(DockerHubLocator{}, "test", "test"))
-	t.Run("simple_match", testFunc(DockerHubLocator{}, "ubuntu", "ubuntu"))
+	t.Run("empty", testFunc(DockerHubLocator{}, "test", "library/test"))
+	t.Run("simple_match", testFunc(DockerHubLocator{}, "ubuntu", "library/ubuntu"))
 	t.Run("non_simple_match", testFunc(DockerHubLocator{}, "ghcr.io/bob", ""))
+	t.Run("namespaced", testFunc(DockerHubLocator{}, "cresta/app:v1", "cresta/app"))
+	t.Run("library", testFunc(DockerHubLocator{}, "docker.io/library/redis", "library/redis"))
+	t.Run("docker_io", testFunc(DockerHubLocator{}, "docker.io/redis", "library/redis"))
+	t.Run("index_docker_io", testFunc(DockerHubLocator{}, "index.docker.io/redis", "library/redis"))
+	t.Run("registry_1_docker_io", testFunc(DockerHubLocator{}, "registry-1.docker.io/redis:7", "library/redis"))
+	t.Run("other_domain", testFunc(DockerHubLocator{MultiURLHostMatcher: MultiURLHostMatcher{ValidDomains: []string{"mirror.example.com"}}}, "mirror.example.com/redis", "library/redis"))
+}
+
+func TestMultiURLHostMatcher_RepositoryForReference(t *testing.T) {
+	m := MultiURLHostMatcher{ValidDomains: []string{"ghcr.io", "localhost:5000"}}
+	require.Equal(t, "a/b", m.RepositoryForURL("ghcr.io/a/b:1.2"))
+	require.Equal(t, "x", m.RepositoryForURL("localhost:5000/x"))
+	require.Equal(t, "", m.RepositoryForURL("localhost:5001/x"))
+	require.Equal(t, "", m.RepositoryForURL("ghcr.io/a/b:"))
+	m.ReturnFullRepo = true
+	require.Equal(t, "ghcr.io/a/b", m.RepositoryForReference(ImageReference{Domain: "ghcr.io", Path: "a/b", Tag: "1.2"}))
 }
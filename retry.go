@@ -0,0 +1,121 @@
+package containerimagelisting
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RetryPolicy retries GET and HEAD requests that failed for reasons that usually go away on their own, like transport
+// errors, 5xx responses and rate limits.  A nil *RetryPolicy does not retry.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a request is sent, including the first time.  Defaults to 3.
+	MaxAttempts int
+	// InitialBackoff is how long to wait before the first retry.  Each retry after that waits twice as long, with
+	// jitter.  Defaults to 500 milliseconds.
+	InitialBackoff time.Duration
+	// MaxBackoff is the longest we wait between attempts.  If the registry asks us to wait longer with Retry-After or
+	// RateLimit-Reset, the response is returned instead.  Defaults to 30 seconds.
+	MaxBackoff time.Duration
+	// sleep waits for d or until ctx is done.  Replaced by tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts == 0 {
+		return 3
+	}
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) initialBackoff() time.Duration {
+	if p.InitialBackoff == 0 {
+		return 500 * time.Millisecond
+	}
+	return p.InitialBackoff
+}
+
+func (p *RetryPolicy) maxBackoff() time.Duration {
+	if p.MaxBackoff == 0 {
+		return 30 * time.Second
+	}
+	return p.MaxBackoff
+}
+
+// backoff is how long to wait after attemptNumber failed, when the registry did not say
+func (p *RetryPolicy) backoff(attemptNumber int) time.Duration {
+	d := p.initialBackoff()
+	for i := 1; i < attemptNumber && d < p.maxBackoff(); i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff() {
+		d = p.maxBackoff()
+	}
+	// Jitter between half and all of d, so clients that failed together do not retry together
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (p *RetryPolicy) wait(ctx context.Context, d time.Duration) error {
+	if p.sleep != nil {
+		return p.sleep(ctx, d)
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// do sends req with client, retrying if the policy allows it.  req must not have a body, since GET and HEAD are the
+// only methods retried.
+func (p *RetryPolicy) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	if p == nil || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+		return client.Do(req)
+	}
+	ctx := req.Context()
+	for attemptNumber := 1; ; attemptNumber++ {
+		resp, err := client.Do(req.Clone(ctx))
+		if attemptNumber >= p.maxAttempts() || !shouldRetry(ctx, resp, err) {
+			return resp, err
+		}
+		delay := p.backoff(attemptNumber)
+		if resp != nil {
+			if asked := parseRetryAfter(resp.Header, time.Now()); asked > 0 {
+				if asked > p.maxBackoff() {
+					return resp, nil
+				}
+				delay = asked
+			}
+			// Drain the body so the connection can be reused
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		if err := p.wait(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// shouldRetry returns true for failures that may go away if the request is sent again
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		var urlErr *url.Error
+		return errors.As(err, &urlErr)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package containerimagelisting
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flakyTransport fails with each of failures in turn, then answers with answer
+type flakyTransport struct {
+	failures []func() (*http.Response, error)
+	answer   string
+	requests int
+}
+
+func (f *flakyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.requests++
+	if f.requests <= len(f.failures) {
+		return f.failures[f.requests-1]()
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader(f.answer)),
+	}, nil
+}
+
+func failWithStatus(statusCode int, header http.Header) func() (*http.Response, error) {
+	return func() (*http.Response, error) {
+		return &http.Response{
+			StatusCode: statusCode,
+			Header:     header,
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+		}, nil
+	}
+}
+
+func failWithTransportError() (*http.Response, error) {
+	return nil, errors.New("connection reset by peer")
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var waits []time.Duration
+	policy := &RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		sleep: func(ctx context.Context, d time.Duration) error {
+			waits = append(waits, d)
+			return nil
+		},
+	}
+	retryAfter := make(http.Header)
+	retryAfter.Set("Retry-After", "7")
+	transport := &flakyTransport{
+		failures: []func() (*http.Response, error){
+			failWithTransportError,
+			failWithStatus(http.StatusServiceUnavailable, nil),
+			failWithStatus(http.StatusTooManyRequests, retryAfter),
+		},
+		answer: `{"name": "test_repo", "tags": ["a"]}`,
+	}
+	d := DockerV2{
+		BaseURL: "http://example.com",
+		Client:  &http.Client{Transport: transport},
+		Retry:   policy,
+	}
+	tags, err := d.ListTags(context.Background(), "test_repo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, tagNames(tags))
+	require.Equal(t, 4, transport.requests)
+	require.Len(t, waits, 3)
+	require.True(t, waits[0] >= 500*time.Millisecond && waits[0] <= time.Second, waits[0])
+	require.True(t, waits[1] >= time.Second && waits[1] <= 2*time.Second, waits[1])
+	require.Equal(t, 7*time.Second, waits[2])
+
+	// Out of attempts
+	waits = nil
+	transport = &flakyTransport{failures: []func() (*http.Response, error){
+		failWithStatus(http.StatusBadGateway, nil),
+		failWithStatus(http.StatusBadGateway, nil),
+		failWithStatus(http.StatusBadGateway, nil),
+		failWithStatus(http.StatusBadGateway, nil),
+	}}
+	d.Client.Transport = transport
+	_, err = d.ListTags(context.Background(), "test_repo")
+	require.ErrorIs(t, err, ErrRegistryUnavailable)
+	require.Equal(t, 4, transport.requests)
+
+	// Registries asking us to wait longer than MaxBackoff get their answer back right away
+	rateLimitReset := make(http.Header)
+	rateLimitReset.Set("RateLimit-Reset", "3600")
+	transport = &flakyTransport{failures: []func() (*http.Response, error){failWithStatus(http.StatusTooManyRequests, rateLimitReset)}}
+	d.Client.Transport = transport
+	_, err = d.ListTags(context.Background(), "test_repo")
+	require.ErrorIs(t, err, ErrRateLimited)
+	var statusErr *StatusError
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, time.Hour, statusErr.RetryAfter)
+	require.Equal(t, 1, transport.requests)
+
+	// Client errors are not retried
+	transport = &flakyTransport{failures: []func() (*http.Response, error){failWithStatus(http.StatusNotFound, nil)}}
+	d.Client.Transport = transport
+	_, err = d.ListTags(context.Background(), "test_repo")
+	require.ErrorIs(t, err, ErrRepositoryNotFound)
+	require.Equal(t, 1, transport.requests)
+}
+
+func TestRetryPolicy_OnlyIdempotentRequests(t *testing.T) {
+	policy := &RetryPolicy{sleep: func(ctx context.Context, d time.Duration) error { return nil }}
+	transport := &flakyTransport{failures: []func() (*http.Response, error){failWithStatus(http.StatusServiceUnavailable, nil)}}
+	client := &http.Client{Transport: transport}
+	req, err := http.NewRequest(http.MethodPost, "http://example.com/token", strings.NewReader("grant_type=password"))
+	require.NoError(t, err)
+	resp, err := policy.do(client, req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, 1, transport.requests)
+
+	// Nil policies never retry
+	var noRetries *RetryPolicy
+	transport.requests = 0
+	req, err = http.NewRequest(http.MethodGet, "http://example.com/v2/", nil)
+	require.NoError(t, err)
+	resp, err = noRetries.do(client, req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestRetryPolicy_ContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := &RetryPolicy{}
+	transport := &flakyTransport{failures: []func() (*http.Response, error){
+		func() (*http.Response, error) {
+			cancel()
+			return failWithStatus(http.StatusServiceUnavailable, nil)()
+		},
+	}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com/v2/", nil)
+	require.NoError(t, err)
+	resp, err := policy.do(&http.Client{Transport: transport}, req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, 1, transport.requests)
+}
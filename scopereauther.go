@@ -2,18 +2,23 @@ package containerimagelisting
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
 type authRequest struct {
 	Type   string
 	Values map[string]string
+	// host is the registry that sent the challenge, used to look up credentials
+	host string
 }
 
 var parserRegex = regexp.MustCompile(`,*([^"]*)="([^"]*)"`)
@@ -48,6 +53,31 @@ func parseWwwAuthenticate(requiredScope string) *authRequest {
 type ScopeReauther struct {
 	Username string
 	Password string
+	// AuthBufferTime is how long before a token expires that it is treated as expired and fetched again.  Defaults to
+	// 5 seconds.
+	AuthBufferTime time.Duration
+	// OAuth2 fetches tokens with the OAuth2 POST flow, falling back to the GET flow for token servers that do not
+	// support it.  It is always used when RefreshToken is set.
+	OAuth2 bool
+	// RefreshToken is used for the OAuth2 refresh_token grant, like the identitytoken docker login stores.  Refresh
+	// tokens returned by the token server replace it.
+	RefreshToken string
+	// ClientID identifies us to OAuth2 token servers.  Defaults to "container-image-listing".
+	ClientID string
+	// Credentials are looked up by registry host when Username and RefreshToken are empty
+	Credentials CredentialProvider
+	// Retry retries token requests made with GET.  Nil means no retries.
+	Retry         *RetryPolicy
+	mu            sync.Mutex
+	tokens        map[string]*scopedToken
+	refreshTokens map[string]string
+}
+
+func (s *ScopeReauther) authBufferTime() time.Duration {
+	if s.AuthBufferTime == 0 {
+		return 5 * time.Second
+	}
+	return s.AuthBufferTime
 }
 
 // Format documented on https://docs.docker.com/registry/spec/auth/token/
@@ -56,6 +86,8 @@ type authResponse struct {
 	AccessToken string    `json:"access_token"`
 	ExpiresIn   int       `json:"expires_in"`
 	IssuedAt    time.Time `json:"issued_at"`
+	// RefreshToken is only returned by the OAuth2 flow
+	RefreshToken string `json:"refresh_token"`
 }
 
 func (a *authResponse) tokenToUse() string {
@@ -66,6 +98,43 @@ func (a *authResponse) tokenToUse() string {
 	return a.AccessToken
 }
 
+func (a *authResponse) expiresAt(now time.Time) time.Time {
+	// Note: Spec says tokens without expires_in last 60 seconds
+	expiresIn := time.Duration(a.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = time.Minute
+	}
+	if a.IssuedAt.IsZero() {
+		return now.Add(expiresIn)
+	}
+	return a.IssuedAt.Add(expiresIn)
+}
+
+// scopedToken is a token from a token server for a single challenge.  It remembers the challenge, so the token can be
+// fetched again before it expires.
+type scopedToken struct {
+	challenge *authRequest
+	token     string
+	expiresAt time.Time
+}
+
+func (t *scopedToken) Wrap(req *http.Request) error {
+	req.Header.Set("Authorization", fmt.Sprintf("%s %s", t.challenge.Type, t.token))
+	return nil
+}
+
+var _ RequestWrapper = &scopedToken{}
+
+// expired returns true once the token can no longer be sent
+func (t *scopedToken) expired(now time.Time) bool {
+	return !t.expiresAt.After(now)
+}
+
+// cacheKey identifies the tokens a challenge can share
+func (a *authRequest) cacheKey() string {
+	return strings.Join([]string{a.Type, a.Values["realm"], a.Values["service"], a.Values["scope"]}, " ")
+}
+
 // RequestWrapper is any type that can wrap a request before it is executed
 type RequestWrapper interface {
 	Wrap(request *http.Request) error
@@ -94,12 +163,90 @@ func (s *ScopeReauther) CheckForReauth(ctx context.Context, originalResp *http.R
 	if parsedRequest == nil {
 		return nil, nil
 	}
-	newReqInto, err := url.Parse(parsedRequest.Values["realm"])
+	if originalResp.Request != nil {
+		parsedRequest.host = originalResp.Request.URL.Host
+	}
+	token, err := s.tokenFor(ctx, parsedRequest, client)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// tokenFor returns a token for the challenge, from the cache if we have one that has not expired
+func (s *ScopeReauther) tokenFor(ctx context.Context, challenge *authRequest, client *http.Client) (*scopedToken, error) {
+	key := challenge.cacheKey()
+	s.mu.Lock()
+	cached := s.tokens[key]
+	s.mu.Unlock()
+	if cached != nil && cached.expiresAt.After(time.Now().Add(s.authBufferTime())) {
+		return cached, nil
+	}
+	// Note: We do not hold the lock while fetching, so concurrent requests may both fetch a token.  That is ok.
+	resp, err := s.fetchToken(ctx, challenge, client)
+	if err != nil {
+		return nil, err
+	}
+	token := &scopedToken{
+		challenge: challenge,
+		token:     resp.tokenToUse(),
+		expiresAt: resp.expiresAt(time.Now()),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokens == nil {
+		s.tokens = make(map[string]*scopedToken)
+	}
+	if _, exists := s.tokens[key]; !exists {
+		s.removeExpiredTokens(time.Now())
+	}
+	s.tokens[key] = token
+	return token, nil
+}
+
+// removeExpiredTokens forgets expired tokens, so scopes that are never asked for again, like repositories listed once
+// by a long running service, do not pile up.  s.mu must be held.
+func (s *ScopeReauther) removeExpiredTokens(now time.Time) {
+	for key, token := range s.tokens {
+		if token.expired(now) {
+			delete(s.tokens, key)
+		}
+	}
+}
+
+// refresh returns wrapper if it is still valid, or a new token for the same challenge if wrapper is about to expire
+func (s *ScopeReauther) refresh(ctx context.Context, wrapper RequestWrapper, client *http.Client) (RequestWrapper, error) {
+	token, ok := wrapper.(*scopedToken)
+	if !ok {
+		return wrapper, nil
+	}
+	refreshed, err := s.tokenFor(ctx, token.challenge, client)
+	if err != nil {
+		return nil, err
+	}
+	return refreshed, nil
+}
+
+// forget removes a token the registry rejected, so the next challenge fetches a new one
+func (s *ScopeReauther) forget(wrapper RequestWrapper) {
+	token, ok := wrapper.(*scopedToken)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokens[token.challenge.cacheKey()] == token {
+		delete(s.tokens, token.challenge.cacheKey())
+	}
+}
+
+func (s *ScopeReauther) fetchTokenWithGet(ctx context.Context, challenge *authRequest, client *http.Client, creds *Credentials) (*authResponse, error) {
+	newReqInto, err := url.Parse(challenge.Values["realm"])
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse realm URL: %w", err)
 	}
 	newQuery := make(url.Values)
-	for k, v := range parsedRequest.Values {
+	for k, v := range challenge.Values {
 		if k == "realm" || k == "" {
 			continue
 		}
@@ -111,13 +258,24 @@ func (s *ScopeReauther) CheckForReauth(ctx context.Context, originalResp *http.R
 	if err != nil {
 		return nil, fmt.Errorf("unable to build request: %w", err)
 	}
-	if s.Username != "" {
-		req.SetBasicAuth(s.Username, s.Password)
+	if creds.Username != "" {
+		req.SetBasicAuth(creds.Username, creds.Password)
 	}
-	resp, err := client.Do(req)
-	if resp.StatusCode != http.StatusOK {
+	return s.doTokenRequest(client, req)
+}
+
+func (s *ScopeReauther) doTokenRequest(client *http.Client, req *http.Request) (*authResponse, error) {
+	resp, err := s.Retry.do(client, req)
+	if err != nil {
 		return nil, fmt.Errorf("unable to fetch auth context: %w", err)
 	}
+	if resp.StatusCode != http.StatusOK {
+		statusErr := newStatusError(resp)
+		if err := resp.Body.Close(); err != nil {
+			return nil, fmt.Errorf("unable to close response body: %w", err)
+		}
+		return nil, fmt.Errorf("unable to fetch auth context: %w", statusErr)
+	}
 	var ret authResponse
 	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
 		return nil, fmt.Errorf("uanble to decode response body as JSON: %w", err)
@@ -125,9 +283,153 @@ func (s *ScopeReauther) CheckForReauth(ctx context.Context, originalResp *http.R
 	if err := resp.Body.Close(); err != nil {
 		return nil, fmt.Errorf("unable to close response body: %w", err)
 	}
-	return RequestWrapperFunc(func(req *http.Request) error {
-		// TODO: Also check expiry token (???)
-		req.Header.Set("Authorization", fmt.Sprintf("%s %s", parsedRequest.Type, ret.tokenToUse()))
-		return nil
-	}), nil
+	return &ret, nil
+}
+
+var errOAuth2Unsupported = errors.New("token server does not support OAuth2")
+
+func (s *ScopeReauther) clientID() string {
+	if s.ClientID == "" {
+		return "container-image-listing"
+	}
+	return s.ClientID
+}
+
+// basicAuthLifetime is how long basic auth credentials are reused before they are looked up again
+const basicAuthLifetime = time.Hour
+
+func (s *ScopeReauther) fetchToken(ctx context.Context, challenge *authRequest, client *http.Client) (*authResponse, error) {
+	creds, err := s.credentials(ctx, challenge.host)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(challenge.Type, "Basic") {
+		// Some registries, like ECR, skip the token server and take the password directly
+		if creds.Username == "" {
+			return nil, fmt.Errorf("registry asked for basic auth but there are no credentials for %s: %w", challenge.host, ErrUnauthorized)
+		}
+		return &authResponse{
+			Token: base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password)),
+			// Passwords do not expire like tokens do, but looking them up again now and then picks up rotated ones
+			ExpiresIn: int(basicAuthLifetime / time.Second),
+		}, nil
+	}
+	if creds.RegistryToken != "" {
+		// No need for the token server, the registry takes this token directly
+		return &authResponse{Token: creds.RegistryToken}, nil
+	}
+	if s.OAuth2 || creds.IdentityToken != "" {
+		ret, err := s.fetchTokenWithOAuth2(ctx, challenge, client, creds)
+		if !errors.Is(err, errOAuth2Unsupported) {
+			return ret, err
+		}
+	}
+	return s.fetchTokenWithGet(ctx, challenge, client, creds)
+}
+
+// credentials returns Username, Password and RefreshToken if any are set, and otherwise asks Credentials
+func (s *ScopeReauther) credentials(ctx context.Context, host string) (*Credentials, error) {
+	if s.Username != "" || s.RefreshToken != "" || s.Credentials == nil || host == "" {
+		return &Credentials{
+			Username:      s.Username,
+			Password:      s.Password,
+			IdentityToken: s.RefreshToken,
+		}, nil
+	}
+	creds, err := s.Credentials.Credentials(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find credentials for %s: %w", host, err)
+	}
+	if creds == nil {
+		return &Credentials{}, nil
+	}
+	return creds, nil
+}
+
+// refreshTokenKey identifies the token server a refresh token is for.  Refresh tokens work for any scope.
+func refreshTokenKey(challenge *authRequest) string {
+	return challenge.Values["realm"] + " " + challenge.Values["service"]
+}
+
+func (s *ScopeReauther) refreshTokenFor(challenge *authRequest, creds *Credentials) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rt, exists := s.refreshTokens[refreshTokenKey(challenge)]; exists {
+		return rt
+	}
+	return creds.IdentityToken
+}
+
+func (s *ScopeReauther) storeRefreshToken(challenge *authRequest, refreshToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if refreshToken == "" {
+		delete(s.refreshTokens, refreshTokenKey(challenge))
+		return
+	}
+	if s.refreshTokens == nil {
+		s.refreshTokens = make(map[string]string)
+	}
+	s.refreshTokens[refreshTokenKey(challenge)] = refreshToken
+}
+
+// fetchTokenWithOAuth2 uses the refresh token if we have one, and the password otherwise
+func (s *ScopeReauther) fetchTokenWithOAuth2(ctx context.Context, challenge *authRequest, client *http.Client, creds *Credentials) (*authResponse, error) {
+	// Documented at https://docs.docker.com/registry/spec/auth/oauth/
+	if refreshToken := s.refreshTokenFor(challenge, creds); refreshToken != "" {
+		form := make(url.Values)
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", refreshToken)
+		ret, err := s.postToken(ctx, challenge, client, form)
+		var statusErr *StatusError
+		rejected := errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusBadRequest || statusErr.StatusCode == http.StatusUnauthorized)
+		if !rejected || creds.Username == "" {
+			return ret, err
+		}
+		// The refresh token expired or was revoked, so get a new one with the password
+		s.storeRefreshToken(challenge, "")
+	}
+	if creds.Username == "" {
+		// Anonymous tokens are only available with GET
+		return nil, errOAuth2Unsupported
+	}
+	form := make(url.Values)
+	form.Set("grant_type", "password")
+	form.Set("username", creds.Username)
+	form.Set("password", creds.Password)
+	form.Set("access_type", "offline")
+	ret, err := s.postToken(ctx, challenge, client, form)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusBadRequest || statusErr.StatusCode == http.StatusUnauthorized) {
+		// Token servers without the password grant usually answer unsupported_grant_type, or reject the POST outright
+		return nil, errOAuth2Unsupported
+	}
+	return ret, err
+}
+
+func (s *ScopeReauther) postToken(ctx context.Context, challenge *authRequest, client *http.Client, form url.Values) (*authResponse, error) {
+	form.Set("client_id", s.clientID())
+	if service := challenge.Values["service"]; service != "" {
+		form.Set("service", service)
+	}
+	if scope := challenge.Values["scope"]; scope != "" {
+		form.Set("scope", scope)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, challenge.Values["realm"], strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ret, err := s.doTokenRequest(client, req)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusMethodNotAllowed) {
+		return nil, errOAuth2Unsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ret.RefreshToken != "" {
+		s.storeRefreshToken(challenge, ret.RefreshToken)
+	}
+	return ret, nil
 }
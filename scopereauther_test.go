@@ -2,10 +2,13 @@ package containerimagelisting
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -75,3 +78,221 @@ func TestScopeReauther(t *testing.T) {
 	require.NoError(t, recvFunc.Wrap(testReq))
 	require.Equal(t, "Bearer abc", testReq.Header.Get("Authorization"))
 }
+
+// tokenServer is a registry that requires bearer tokens from its own token endpoint
+type tokenServer struct {
+	expiresIn     int
+	tokensIssued  int
+	unauthorized  int
+	validTokens   map[string]bool
+	mu            sync.Mutex
+	tagListAnswer string
+}
+
+func (s *tokenServer) RoundTrip(r *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.URL.Path == "/token" {
+		s.tokensIssued++
+		token := fmt.Sprintf("token-%d", s.tokensIssued)
+		s.validTokens[token] = true
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(fmt.Sprintf(`{"token": "%s", "expires_in": %d}`, token, s.expiresIn))),
+		}, nil
+	}
+	if !s.validTokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+		s.unauthorized++
+		header := make(http.Header)
+		repository := "test_repo"
+		if strings.HasSuffix(r.URL.Path, "/tags/list") {
+			repository = strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v2/"), "/tags/list")
+		}
+		header.Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="http://example.com/token",service="example.com",scope="repository:%s:pull"`, repository))
+		return &http.Response{
+			StatusCode: http.StatusUnauthorized,
+			Header:     header,
+			Body:       ioutil.NopCloser(strings.NewReader(`{"errors": [{"code": "UNAUTHORIZED", "message": "authentication required"}]}`)),
+		}, nil
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader(s.tagListAnswer)),
+	}, nil
+}
+
+func TestDockerV2_CachesTokens(t *testing.T) {
+	server := &tokenServer{
+		expiresIn:     300,
+		validTokens:   make(map[string]bool),
+		tagListAnswer: `{"name": "test_repo", "tags": ["a"]}`,
+	}
+	d := DockerV2{
+		BaseURL: "http://example.com",
+		Client:  &http.Client{Transport: server},
+		ReAuth:  &ScopeReauther{},
+	}
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		tags, err := d.ListTags(ctx, "test_repo")
+		require.NoError(t, err)
+		require.Len(t, tags, 1)
+	}
+	require.Equal(t, 1, server.tokensIssued)
+	require.Equal(t, 1, server.unauthorized)
+
+	// A revoked token is dropped and replaced
+	server.validTokens = make(map[string]bool)
+	_, err := d.ListTags(ctx, "test_repo")
+	require.NoError(t, err)
+	require.Equal(t, 2, server.tokensIssued)
+	require.Equal(t, 2, server.unauthorized)
+
+	// Tokens about to expire are refreshed before they are sent
+	server.expiresIn = 1
+	d.ReAuth.AuthBufferTime = 10 * time.Minute
+	for i := 0; i < 2; i++ {
+		_, err = d.ListTags(ctx, "test_repo")
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, server.unauthorized)
+	require.Equal(t, 4, server.tokensIssued)
+}
+
+func TestDockerV2_ForgetsExpiredTokens(t *testing.T) {
+	server := &tokenServer{
+		expiresIn:     300,
+		validTokens:   make(map[string]bool),
+		tagListAnswer: `{"name": "test_repo", "tags": ["a"]}`,
+	}
+	d := DockerV2{
+		BaseURL: "http://example.com",
+		Client:  &http.Client{Transport: server},
+		ReAuth:  &ScopeReauther{},
+	}
+	ctx := context.Background()
+	for _, repo := range []string{"first", "second"} {
+		_, err := d.ListTags(ctx, repo)
+		require.NoError(t, err)
+	}
+	require.Len(t, d.ReAuth.tokens, 2)
+	require.Len(t, d.authWrappers, 2)
+
+	for _, token := range d.ReAuth.tokens {
+		token.expiresAt = time.Now().Add(-time.Minute)
+	}
+	_, err := d.ListTags(ctx, "third")
+	require.NoError(t, err)
+	require.Len(t, d.ReAuth.tokens, 1)
+	require.Len(t, d.authWrappers, 1)
+	require.Contains(t, d.authWrappers, repositoryAuthKey("third"))
+}
+
+func TestScopeReauther_OAuth2(t *testing.T) {
+	x := ScopeReauther{
+		Username: "john",
+		Password: "doe",
+		OAuth2:   true,
+	}
+	challenge := &authRequest{
+		Type: "Bearer",
+		Values: map[string]string{
+			"realm":   "https://auth.example.com/token",
+			"service": "example.com",
+			"scope":   "repository:samalba/my-app:pull",
+		},
+	}
+	var grants []string
+	supportsPost := true
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if r.Method == http.MethodGet {
+				u, _, ok := r.BasicAuth()
+				require.True(t, ok)
+				require.Equal(t, "john", u)
+				grants = append(grants, "get")
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       ioutil.NopCloser(strings.NewReader(`{"token": "from_get"}`)),
+				}, nil
+			}
+			if !supportsPost {
+				return &http.Response{
+					StatusCode: http.StatusNotFound,
+					Body:       ioutil.NopCloser(strings.NewReader("")),
+				}, nil
+			}
+			require.NoError(t, r.ParseForm())
+			require.Equal(t, "container-image-listing", r.PostForm.Get("client_id"))
+			require.Equal(t, "example.com", r.PostForm.Get("service"))
+			require.Equal(t, "repository:samalba/my-app:pull", r.PostForm.Get("scope"))
+			grant := r.PostForm.Get("grant_type")
+			grants = append(grants, grant)
+			switch grant {
+			case "password":
+				require.Equal(t, "doe", r.PostForm.Get("password"))
+			case "refresh_token":
+				require.Equal(t, "refresh_1", r.PostForm.Get("refresh_token"))
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(strings.NewReader(`{"access_token": "from_post", "refresh_token": "refresh_1"}`)),
+			}, nil
+		}),
+	}
+	ctx := context.Background()
+	token, err := x.fetchToken(ctx, challenge, client)
+	require.NoError(t, err)
+	require.Equal(t, "from_post", token.tokenToUse())
+	_, err = x.fetchToken(ctx, challenge, client)
+	require.NoError(t, err)
+	require.Equal(t, []string{"password", "refresh_token"}, grants)
+
+	supportsPost = false
+	x.storeRefreshToken(challenge, "")
+	token, err = x.fetchToken(ctx, challenge, client)
+	require.NoError(t, err)
+	require.Equal(t, "from_get", token.tokenToUse())
+}
+
+func TestScopeReauther_OAuth2Fallback(t *testing.T) {
+	challenge := &authRequest{
+		Type: "Bearer",
+		Values: map[string]string{
+			"realm":   "https://auth.example.com/token",
+			"service": "example.com",
+			"scope":   "repository:samalba/my-app:pull",
+		},
+	}
+	for _, postStatus := range []int{http.StatusBadRequest, http.StatusUnauthorized} {
+		x := ScopeReauther{
+			Username: "john",
+			Password: "doe",
+			OAuth2:   true,
+		}
+		var methods []string
+		client := &http.Client{
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				methods = append(methods, r.Method)
+				if r.Method == http.MethodPost {
+					return &http.Response{
+						StatusCode: postStatus,
+						Body:       ioutil.NopCloser(strings.NewReader(`{"error": "unsupported_grant_type"}`)),
+					}, nil
+				}
+				u, p, ok := r.BasicAuth()
+				require.True(t, ok)
+				require.Equal(t, "john", u)
+				require.Equal(t, "doe", p)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       ioutil.NopCloser(strings.NewReader(`{"token": "from_get"}`)),
+				}, nil
+			}),
+		}
+		token, err := x.fetchToken(context.Background(), challenge, client)
+		require.NoError(t, err, postStatus)
+		require.Equal(t, "from_get", token.tokenToUse())
+		require.Equal(t, []string{http.MethodPost, http.MethodGet}, methods)
+	}
+}
@@ -0,0 +1,99 @@
+package containerimagelisting
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// SearchResult is what one registry answered to RegistryFinder.Search
+type SearchResult struct {
+	// Source is the Name of the registry, or its host if it has no Name
+	Source string
+	// Repository is the repository that was asked for, like library/redis for docker hub
+	Repository string
+	Tags       []Tag
+	// Err is why the registry could not list tags.  It matches ErrRepositoryNotFound if the registry does not have
+	// the repository.
+	Err error
+}
+
+// Found returns true if the registry has the repository
+func (s *SearchResult) Found() bool {
+	return s.Err == nil
+}
+
+// Search asks every registry for a repository at the same time, to find out where a name like cresta/foo is published.
+// Names with a registry host, like ghcr.io/cresta/foo, only ask the registry for that host.  There is one result per
+// registry asked, in the order of Registries, whether or not the registry had the repository.  Registries with
+// ExcludeFromSearch set are only asked for names with their host.
+func (r *RegistryFinder) Search(ctx context.Context, name string) ([]SearchResult, error) {
+	ref, err := ParseImageReference(name)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Domain != "" {
+		registry, repository := r.findRegistry(ref)
+		if registry == nil {
+			return nil, fmt.Errorf("unable to find registry for %s: %w", name, ErrNoRegistryMatched)
+		}
+		tags, err := registry.Registry.ListTags(ctx, repository)
+		return []SearchResult{
+			{
+				Source:     registry.name(),
+				Repository: repository,
+				Tags:       tags,
+				Err:        err,
+			},
+		}, nil
+	}
+
+	ret := make([]SearchResult, 0, len(r.Registries))
+	searched := make([]*RegistryWithFinder, 0, len(r.Registries))
+	for i := range r.Registries {
+		registry := &r.Registries[i]
+		if registry.ExcludeFromSearch {
+			continue
+		}
+		// Locators that match bare names, like docker hub's, know how to rewrite them.  Every other registry gets the
+		// name as is.
+		repository := locateRepository(registry.RepositoryLocator, ref)
+		if repository == "" {
+			repository = ref.Path
+		}
+		ret = append(ret, SearchResult{
+			Source:     registry.name(),
+			Repository: repository,
+		})
+		searched = append(searched, registry)
+	}
+	var wg sync.WaitGroup
+	for i := range ret {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ret[i].Tags, ret[i].Err = searched[i].Registry.ListTags(ctx, ret[i].Repository)
+		}(i)
+	}
+	wg.Wait()
+	return ret, nil
+}
+
+// name describes the registry for results, using its Name or else its host
+func (r *RegistryWithFinder) name() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	switch registry := r.Registry.(type) {
+	case *DockerV2:
+		return registryHostOf(registry.BaseURL)
+	case *Quay:
+		return registryHostOf(registry.baseURL())
+	case *ECR:
+		if registry.RegistryID != "" {
+			return "ecr " + registry.RegistryID
+		}
+		return "ecr"
+	}
+	return fmt.Sprintf("%T", r.Registry)
+}
@@ -0,0 +1,79 @@
+package containerimagelisting
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryFinder_Search(t *testing.T) {
+	var mu sync.Mutex
+	var asked []string
+	registry := func(name string, has map[string]bool) Registry {
+		return &registryFunc{listTags: func(ctx context.Context, repository string) ([]Tag, error) {
+			mu.Lock()
+			asked = append(asked, name+" "+repository)
+			mu.Unlock()
+			if !has[repository] {
+				return nil, &StatusError{StatusCode: 404}
+			}
+			return []Tag{&staticTag{tag: name}}, nil
+		}}
+	}
+	finder := RegistryFinder{
+		Registries: []RegistryWithFinder{
+			{
+				Registry:          registry("quay", map[string]bool{"cresta/foo": true}),
+				RepositoryLocator: &MultiURLHostMatcher{ValidDomains: []string{"quay.io"}},
+				Name:              "quay.io",
+			},
+			{
+				Registry:          registry("ghcr", map[string]bool{"cresta/foo": true}),
+				RepositoryLocator: &MultiURLHostMatcher{ValidDomains: []string{"ghcr.io"}},
+				ExcludeFromSearch: true,
+			},
+			{
+				Registry:          &DockerV2{BaseURL: "https://registry-1.docker.io/"},
+				RepositoryLocator: &DockerHubLocator{},
+			},
+		},
+	}
+	// Replace docker hub after the fact, so its name still comes from the DockerV2 base URL
+	dockerHub := registry("dockerhub", map[string]bool{"library/redis": true})
+	require.Equal(t, "docker.io", finder.Registries[2].name())
+	finder.Registries[2].Registry = dockerHub
+	finder.Registries[2].Name = "docker.io"
+	ctx := context.Background()
+
+	results, err := finder.Search(ctx, "cresta/foo")
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, "quay.io", results[0].Source)
+	require.True(t, results[0].Found())
+	require.Equal(t, []string{"quay"}, tagNames(results[0].Tags))
+	require.Equal(t, "docker.io", results[1].Source)
+	require.False(t, results[1].Found())
+	require.ErrorIs(t, results[1].Err, ErrRepositoryNotFound)
+	require.ElementsMatch(t, []string{"quay cresta/foo", "dockerhub cresta/foo"}, asked)
+
+	asked = nil
+	results, err = finder.Search(ctx, "redis")
+	require.NoError(t, err)
+	require.Equal(t, "library/redis", results[1].Repository)
+	require.True(t, results[1].Found())
+	require.Equal(t, "redis", results[0].Repository)
+	require.False(t, results[0].Found())
+
+	// Names with a host only ask that host, even if it is excluded from search
+	asked = nil
+	results, err = finder.Search(ctx, "ghcr.io/cresta/foo")
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.True(t, results[0].Found())
+	require.Equal(t, []string{"ghcr cresta/foo"}, asked)
+
+	_, err = finder.Search(ctx, "example.com/cresta/foo")
+	require.ErrorIs(t, err, ErrNoRegistryMatched)
+}
@@ -0,0 +1,251 @@
+package tagselect
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constraint is a version range like ">=1.2 <2", "~1.4", "^3" or "1.x || 2.x"
+type Constraint struct {
+	raw string
+	// alternatives are joined with ||.  A version matches if it matches every comparator of any alternative.
+	alternatives [][]comparator
+	// mentionsPrerelease is true if a version in the constraint is a pre-release, like >=2.0.0-rc.1, which means the
+	// caller wants pre-releases
+	mentionsPrerelease bool
+}
+
+type comparator struct {
+	op string
+	v  *Version
+}
+
+func (c comparator) matches(v *Version) bool {
+	cmp := v.Compare(c.v)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		if c.v.parts < 3 {
+			// !=1.2 leaves out every 1.2.x, not only 1.2.0
+			return cmp < 0 || v.Compare(bump(c.v, c.v.parts)) >= 0
+		}
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// ParseConstraint parses a constraint.  Comparators are separated by spaces or commas, and all of them must match.
+// Operators may be written apart from their version, like ">= 1.2".
+// Alternatives are separated by ||.  Supported comparators are:
+//
+//	1.2.3, =1.2.3   exactly 1.2.3.  Partial versions like 1.2, 1.2.x or 1.* match any 1.2.x or 1.x.x.
+//	!=1.2.3         anything but 1.2.3.  !=1.2 is anything but 1.2.x: <1.2.0 || >=1.3.0-0.
+//	>, >=, <, <=    ranges, like >=1.2 <2
+//	~1.4            patch releases: >=1.4.0 <1.5.0.  ~1 is >=1.0.0 <2.0.0.
+//	^3              compatible releases: >=3.0.0 <4.0.0.  ^0.2.3 is >=0.2.3 <0.3.0.
+//	*, x or empty   any version
+func ParseConstraint(s string) (*Constraint, error) {
+	ret := Constraint{raw: s}
+	alternatives := strings.Split(s, "||")
+	for _, alternative := range alternatives {
+		if len(alternatives) > 1 && strings.TrimSpace(alternative) == "" {
+			return nil, fmt.Errorf("invalid constraint %q: empty alternative", s)
+		}
+		var comparators []comparator
+		for _, field := range joinOperators(strings.FieldsFunc(alternative, func(r rune) bool { return r == ' ' || r == ',' || r == '\t' })) {
+			parsed, prerelease, err := parseComparator(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid constraint %q: %w", s, err)
+			}
+			if prerelease {
+				ret.mentionsPrerelease = true
+			}
+			comparators = append(comparators, parsed...)
+		}
+		ret.alternatives = append(ret.alternatives, comparators)
+	}
+	return &ret, nil
+}
+
+// MustParseConstraint is ParseConstraint that panics on invalid constraints, for constraints written in code
+func MustParseConstraint(s string) *Constraint {
+	ret, err := ParseConstraint(s)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+func (c *Constraint) String() string {
+	return c.raw
+}
+
+// Check returns true if v is in the range.  Pre-releases are only checked against the range, so callers that want to
+// leave out pre-releases must do so themselves, like Select does.
+func (c *Constraint) Check(v *Version) bool {
+	for _, alternative := range c.alternatives {
+		matched := true
+		for _, comp := range alternative {
+			if !comp.matches(v) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// joinOperators joins operators written apart from their version, like the ">=" and "1.2" of ">= 1.2", back together
+func joinOperators(fields []string) []string {
+	ret := make([]string, 0, len(fields))
+	for i := 0; i < len(fields); i++ {
+		if isOperator(fields[i]) && i+1 < len(fields) {
+			ret = append(ret, fields[i]+fields[i+1])
+			i++
+			continue
+		}
+		ret = append(ret, fields[i])
+	}
+	return ret
+}
+
+func isOperator(s string) bool {
+	for _, o := range operators {
+		if s == o {
+			return true
+		}
+	}
+	return false
+}
+
+// operators are checked longest first, so >= is not read as >
+var operators = []string{">=", "<=", "!=", ">", "<", "=", "~", "^"}
+
+// parseComparator turns one comparator into the plain comparisons it means.  It also returns whether the version
+// written in the comparator is a pre-release.
+func parseComparator(s string) ([]comparator, bool, error) {
+	op := ""
+	for _, o := range operators {
+		if strings.HasPrefix(s, o) {
+			op = o
+			break
+		}
+	}
+	versionPart := strings.TrimSpace(strings.TrimPrefix(s, op))
+	if versionPart == "" {
+		return nil, false, fmt.Errorf("%q is missing a version", s)
+	}
+	if isWildcard(versionPart) {
+		if op == "" || op == "=" || op == ">=" || op == "<=" {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("%q uses a wildcard with %s", s, op)
+	}
+	v, err := ParseVersion(trimWildcards(versionPart))
+	if err != nil {
+		return nil, false, err
+	}
+	prerelease := v.IsPrerelease()
+	// lower is v with the missing numbers filled in as zeros, like 1.2.0 for 1.2
+	filled := *v
+	filled.parts = 3
+	lower := &filled
+	switch op {
+	case "", "=":
+		if v.parts == 3 {
+			return []comparator{{"=", v}}, prerelease, nil
+		}
+		return []comparator{{">=", lower}, {"<", bump(v, v.parts)}}, prerelease, nil
+	case "!=":
+		// Partial versions are kept, so matches can leave out everything they stand for
+		return []comparator{{"!=", v}}, prerelease, nil
+	case ">=", "<":
+		if op == "<" && v.parts < 3 {
+			// <2 leaves out 2.0.0-rc.1 too, while <2.0.0 is exactly what semver says
+			return []comparator{{"<", upperBound(lower)}}, prerelease, nil
+		}
+		return []comparator{{op, lower}}, prerelease, nil
+	case ">":
+		if v.parts == 3 {
+			return []comparator{{">", v}}, prerelease, nil
+		}
+		// >1.2 means newer than every 1.2.x
+		return []comparator{{">=", bump(v, v.parts)}}, prerelease, nil
+	case "<=":
+		if v.parts == 3 {
+			return []comparator{{"<=", v}}, prerelease, nil
+		}
+		return []comparator{{"<", bump(v, v.parts)}}, prerelease, nil
+	case "~":
+		if v.parts == 1 {
+			return []comparator{{">=", lower}, {"<", bump(v, 1)}}, prerelease, nil
+		}
+		return []comparator{{">=", lower}, {"<", bump(v, 2)}}, prerelease, nil
+	case "^":
+		switch {
+		case v.Major > 0 || v.parts == 1:
+			return []comparator{{">=", lower}, {"<", bump(v, 1)}}, prerelease, nil
+		case v.Minor > 0 || v.parts == 2:
+			return []comparator{{">=", lower}, {"<", bump(v, 2)}}, prerelease, nil
+		}
+		return []comparator{{">=", lower}, {"<", bump(v, 3)}}, prerelease, nil
+	}
+	return nil, false, fmt.Errorf("unknown operator in %q", s)
+}
+
+// bump returns the first version past every version that starts with the first parts numbers of v, like 1.3.0-0 for
+// 1.2 when parts is 2
+func bump(v *Version, parts int) *Version {
+	ret := Version{Major: v.Major, parts: 3}
+	switch parts {
+	case 1:
+		ret.Major++
+	case 2:
+		ret.Minor = v.Minor + 1
+	default:
+		ret.Minor = v.Minor
+		ret.Patch = v.Patch + 1
+	}
+	return upperBound(&ret)
+}
+
+// upperBound makes an exclusive upper bound leave out the pre-releases of the bound too, so <2 and ^1 do not match
+// 2.0.0-rc.1
+func upperBound(v *Version) *Version {
+	if v.IsPrerelease() {
+		return v
+	}
+	ret := *v
+	ret.Prerelease = []string{"0"}
+	return &ret
+}
+
+func isWildcard(s string) bool {
+	return s == "*" || s == "x" || s == "X"
+}
+
+// trimWildcards turns 1.2.x and 1.* into the partial versions 1.2 and 1
+func trimWildcards(s string) string {
+	for {
+		trimmed := s
+		for _, suffix := range []string{".x", ".X", ".*"} {
+			trimmed = strings.TrimSuffix(trimmed, suffix)
+		}
+		if trimmed == s {
+			return s
+		}
+		s = trimmed
+	}
+}
@@ -0,0 +1,59 @@
+package tagselect
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConstraint_Check(t *testing.T) {
+	testFunc := func(constraint string, matches []string, doesNotMatch []string) func(t *testing.T) {
+		return func(t *testing.T) {
+			c, err := ParseConstraint(constraint)
+			require.NoError(t, err)
+			for _, s := range matches {
+				v, err := ParseVersion(s)
+				require.NoError(t, err)
+				require.True(t, c.Check(v), "%s should match %s", s, constraint)
+			}
+			for _, s := range doesNotMatch {
+				v, err := ParseVersion(s)
+				require.NoError(t, err)
+				require.False(t, c.Check(v), "%s should not match %s", s, constraint)
+			}
+		}
+	}
+	t.Run("any", testFunc("", []string{"0.0.1", "99.0.0"}, nil))
+	t.Run("star", testFunc("*", []string{"0.0.1", "99.0.0"}, nil))
+	t.Run("exact", testFunc("1.2.3", []string{"1.2.3", "v1.2.3+build"}, []string{"1.2.4", "1.2.3-rc.1"}))
+	t.Run("partial", testFunc("1.2", []string{"1.2.0", "1.2.9"}, []string{"1.3.0", "1.1.9"}))
+	t.Run("wildcard", testFunc("1.x", []string{"1.0.0", "1.9.9"}, []string{"2.0.0", "0.9.0"}))
+	t.Run("range", testFunc(">=1.2 <2", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0", "2.0.0-rc.1"}))
+	t.Run("comma_range", testFunc(">=1.2, <2", []string{"1.5.0"}, []string{"2.1.0"}))
+	t.Run("greater_partial", testFunc(">1.2", []string{"1.3.0"}, []string{"1.2.9"}))
+	t.Run("less_equal_partial", testFunc("<=1.2", []string{"1.2.9"}, []string{"1.3.0"}))
+	t.Run("not_equal", testFunc("!=1.2.3", []string{"1.2.4"}, []string{"1.2.3"}))
+	t.Run("not_equal_partial", testFunc("!=1.2", []string{"1.1.9", "1.3.0-rc.1", "1.3.0"}, []string{"1.2.0", "1.2.5"}))
+	t.Run("not_equal_wildcard", testFunc("!=1.x", []string{"0.9.0", "2.0.0"}, []string{"1.0.0", "1.9.9"}))
+	t.Run("tilde", testFunc("~1.4", []string{"1.4.0", "1.4.9"}, []string{"1.5.0", "1.3.9"}))
+	t.Run("tilde_patch", testFunc("~1.4.2", []string{"1.4.2", "1.4.9"}, []string{"1.4.1", "1.5.0"}))
+	t.Run("tilde_major", testFunc("~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}))
+	t.Run("caret", testFunc("^3", []string{"3.0.0", "3.99.0"}, []string{"4.0.0", "2.9.9"}))
+	t.Run("caret_minor", testFunc("^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"1.2.2", "2.0.0"}))
+	t.Run("caret_zero", testFunc("^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0"}))
+	t.Run("caret_zero_zero", testFunc("^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}))
+	t.Run("or", testFunc("1.x || >=3", []string{"1.2.0", "3.0.0"}, []string{"2.0.0"}))
+	t.Run("spaced", testFunc(">= 1.2", []string{"1.2.0", "2.0.0"}, []string{"1.1.9"}))
+	t.Run("spaced_range", testFunc("> 1.2, < 2", []string{"1.3.0"}, []string{"1.2.9", "2.0.0"}))
+	t.Run("spaced_or", testFunc("~ 1.4 || ^ 3", []string{"1.4.2", "3.1.0"}, []string{"1.5.0", "4.0.0"}))
+
+	for _, invalid := range []string{"latest", ">=", "~*", "1.2 ||", "=>1.2", "1.2 - 2", ">= ", "1.2 <"} {
+		_, err := ParseConstraint(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestConstraint_MentionsPrerelease(t *testing.T) {
+	require.False(t, MustParseConstraint(">=1.2 <2").mentionsPrerelease)
+	require.True(t, MustParseConstraint(">=2.0.0-rc.1").mentionsPrerelease)
+}
@@ -0,0 +1,223 @@
+package tagselect
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	containerimagelisting "github.com/cresta/container-image-listing"
+)
+
+// TagScheme is a way of naming tags that says which tag is newer, like semantic versions or dates
+type TagScheme interface {
+	// ParseTag returns the version of tag, or false if the tag does not follow the scheme
+	ParseTag(tag string) (*SchemeVersion, bool)
+}
+
+// SchemeVersion is what a TagScheme found in a tag.  Anything the scheme does not use is left as the zero value.
+type SchemeVersion struct {
+	// Semver is set by schemes that contain a semantic version
+	Semver *Version
+	// Time is when the tag was built, from a date or timestamp in the tag
+	Time time.Time
+	// Build is a build number, which orders tags with the same Time
+	Build int64
+	// Sha is the git commit the tag was built from.  It does not order tags.
+	Sha string
+	// Branch is the git branch the tag was built from.  It does not order tags.
+	Branch string
+}
+
+// Compare returns -1, 0 or 1 if v is older than, as old as, or newer than other.  Semantic versions are compared
+// first, then Time, then Build.  Tags that only differ by Sha or Branch are as old as each other.
+func (v *SchemeVersion) Compare(other *SchemeVersion) int {
+	switch {
+	case v.Semver != nil && other.Semver != nil:
+		if c := v.Semver.Compare(other.Semver); c != 0 {
+			return c
+		}
+	case v.Semver != nil:
+		return 1
+	case other.Semver != nil:
+		return -1
+	}
+	switch {
+	case v.Time.After(other.Time):
+		return 1
+	case v.Time.Before(other.Time):
+		return -1
+	}
+	return compareInt(v.Build, other.Build)
+}
+
+// TagSchemeFunc is a function wrapper for TagScheme
+type TagSchemeFunc func(tag string) (*SchemeVersion, bool)
+
+func (t TagSchemeFunc) ParseTag(tag string) (*SchemeVersion, bool) {
+	return t(tag)
+}
+
+var _ TagScheme = TagSchemeFunc(nil)
+
+// RegexScheme parses tags with a regex.  These named groups are understood, and every other group is ignored:
+//
+//	version  a semantic version, like 1.2.3
+//	date     a date or timestamp, parsed with DateLayouts
+//	build    a build number
+//	sha      a git commit
+//	branch   a git branch
+type RegexScheme struct {
+	Regex *regexp.Regexp
+	// DateLayouts are the time.Parse layouts tried, in order, for the date group.  Dates are in UTC.
+	DateLayouts []string
+}
+
+var _ TagScheme = &RegexScheme{}
+
+// NewRegexScheme compiles regex into a RegexScheme.  It returns an error if regex does not compile, or has a date
+// group without any dateLayouts.
+func NewRegexScheme(regex string, dateLayouts ...string) (*RegexScheme, error) {
+	r, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compile tag scheme regex: %w", err)
+	}
+	if r.SubexpIndex("date") != -1 && len(dateLayouts) == 0 {
+		return nil, fmt.Errorf("tag scheme regex %s has a date group, but no date layouts", regex)
+	}
+	return &RegexScheme{
+		Regex:       r,
+		DateLayouts: dateLayouts,
+	}, nil
+}
+
+// MustRegexScheme is NewRegexScheme that panics on errors, for schemes written in code
+func MustRegexScheme(regex string, dateLayouts ...string) *RegexScheme {
+	ret, err := NewRegexScheme(regex, dateLayouts...)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+func (r *RegexScheme) ParseTag(tag string) (*SchemeVersion, bool) {
+	m := r.Regex.FindStringSubmatch(tag)
+	if m == nil {
+		return nil, false
+	}
+	var ret SchemeVersion
+	for i, name := range r.Regex.SubexpNames() {
+		val := m[i]
+		if name == "" || val == "" {
+			continue
+		}
+		switch name {
+		case "version":
+			v, err := ParseVersion(val)
+			if err != nil {
+				return nil, false
+			}
+			ret.Semver = v
+		case "date":
+			t, ok := r.parseDate(val)
+			if !ok {
+				return nil, false
+			}
+			ret.Time = t
+		case "build":
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, false
+			}
+			ret.Build = n
+		case "sha":
+			ret.Sha = val
+		case "branch":
+			ret.Branch = val
+		}
+	}
+	return &ret, true
+}
+
+func (r *RegexScheme) parseDate(val string) (time.Time, bool) {
+	for _, layout := range r.DateLayouts {
+		if t, err := time.ParseInLocation(layout, val, time.UTC); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Built in schemes
+var (
+	// SemverScheme parses tags that are semantic versions, like v1.2.3
+	SemverScheme TagScheme = TagSchemeFunc(func(tag string) (*SchemeVersion, bool) {
+		v, err := ParseVersion(tag)
+		if err != nil {
+			return nil, false
+		}
+		return &SchemeVersion{Semver: v}, true
+	})
+	// CalVerScheme parses dated tags with an optional build number and git sha, like 2026.10.18, 2026.10.18.2 or
+	// 2026.10.18-abc1234
+	CalVerScheme = MustRegexScheme(`^(?P<date>\d{4}\.\d{2}\.\d{2})(?:\.(?P<build>\d+))?(?:-(?P<sha>[0-9a-f]{7,40}))?$`, "2006.01.02")
+	// TimestampedShaScheme parses tags with an optional branch, a timestamp and a git sha, like
+	// main-20261018T1200-abc1234 or 20261018T120000-abc1234
+	TimestampedShaScheme = MustRegexScheme(`^(?:(?P<branch>[A-Za-z0-9._-]+)-)?(?P<date>\d{8}T\d{4}(?:\d{2})?)-(?P<sha>[0-9a-f]{7,40})$`, "20060102T150405", "20060102T1504")
+	// GitShaScheme parses tags that are only a git sha, like sha-abc1234.  These tags have no order, so they are best
+	// combined with other schemes with AnyScheme.
+	GitShaScheme = MustRegexScheme(`^sha-(?P<sha>[0-9a-f]{7,40})$`)
+)
+
+// AnyScheme parses tags with the first of schemes that understands them, so tags named in different ways can be
+// ordered together by date.  Put CalVerScheme before SemverScheme, since calver tags like 2026.10.18-abc1234 are also
+// valid (pre-release) semantic versions.
+func AnyScheme(schemes ...TagScheme) TagScheme {
+	return TagSchemeFunc(func(tag string) (*SchemeVersion, bool) {
+		for _, s := range schemes {
+			if v, ok := s.ParseTag(tag); ok {
+				return v, true
+			}
+		}
+		return nil, false
+	})
+}
+
+// SchemeTag is a tag with the version a TagScheme parsed from it
+type SchemeTag struct {
+	containerimagelisting.Tag
+	Version *SchemeVersion
+}
+
+// SortByScheme returns the tags that follow scheme, newest first.  Tags that do not follow scheme are left out.  Tags
+// that are as old as each other are ordered by name.
+func SortByScheme(tags []containerimagelisting.Tag, scheme TagScheme) []SchemeTag {
+	ret := make([]SchemeTag, 0, len(tags))
+	for _, t := range tags {
+		v, ok := scheme.ParseTag(t.Tag())
+		if !ok {
+			continue
+		}
+		ret = append(ret, SchemeTag{
+			Tag:     t,
+			Version: v,
+		})
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		if c := ret[i].Version.Compare(ret[j].Version); c != 0 {
+			return c > 0
+		}
+		return ret[i].Tag.Tag() < ret[j].Tag.Tag()
+	})
+	return ret
+}
+
+// LatestByScheme returns the newest tag that follows scheme, or ErrNoMatchingTag
+func LatestByScheme(tags []containerimagelisting.Tag, scheme TagScheme) (*SchemeTag, error) {
+	sorted := SortByScheme(tags, scheme)
+	if len(sorted) == 0 {
+		return nil, fmt.Errorf("%w: no tag follows the scheme", ErrNoMatchingTag)
+	}
+	return &sorted[0], nil
+}
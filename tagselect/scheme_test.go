@@ -0,0 +1,81 @@
+package tagselect
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuiltInSchemes(t *testing.T) {
+	testFunc := func(scheme TagScheme, tag string, expected *SchemeVersion) func(t *testing.T) {
+		return func(t *testing.T) {
+			v, ok := scheme.ParseTag(tag)
+			if expected == nil {
+				require.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			require.Equal(t, expected, v)
+		}
+	}
+	t.Run("calver", testFunc(CalVerScheme, "2026.10.18", &SchemeVersion{Time: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)}))
+	t.Run("calver_build_sha", testFunc(CalVerScheme, "2026.10.18.3-abc1234", &SchemeVersion{Time: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), Build: 3, Sha: "abc1234"}))
+	t.Run("calver_bad_date", testFunc(CalVerScheme, "2026.13.18", nil))
+	t.Run("timestamped", testFunc(TimestampedShaScheme, "main-20261018T1200-abc1234", &SchemeVersion{Time: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC), Sha: "abc1234", Branch: "main"}))
+	t.Run("timestamped_seconds", testFunc(TimestampedShaScheme, "20261018T120030-abc1234", &SchemeVersion{Time: time.Date(2026, 10, 18, 12, 0, 30, 0, time.UTC), Sha: "abc1234"}))
+	t.Run("timestamped_branch_with_dashes", testFunc(TimestampedShaScheme, "feature-x-20261018T1200-abc1234", &SchemeVersion{Time: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC), Sha: "abc1234", Branch: "feature-x"}))
+	t.Run("git_sha", testFunc(GitShaScheme, "sha-abc1234", &SchemeVersion{Sha: "abc1234"}))
+	t.Run("git_sha_not_hex", testFunc(GitShaScheme, "sha-xyz1234", nil))
+	t.Run("semver", testFunc(SemverScheme, "v1.2.3", &SchemeVersion{Semver: &Version{Major: 1, Minor: 2, Patch: 3, parts: 3}}))
+	t.Run("semver_not", testFunc(SemverScheme, "main-20261018T1200-abc1234", nil))
+}
+
+func TestRegexScheme(t *testing.T) {
+	scheme, err := NewRegexScheme(`^release-(?P<version>\d+\.\d+\.\d+)-(?P<build>\d+)$`)
+	require.NoError(t, err)
+	v, ok := scheme.ParseTag("release-1.2.3-45")
+	require.True(t, ok)
+	require.Equal(t, "1.2.3", v.Semver.String())
+	require.Equal(t, int64(45), v.Build)
+
+	_, err = NewRegexScheme(`^(?P<date>\d+)$`)
+	require.Error(t, err)
+	_, err = NewRegexScheme(`^(`)
+	require.Error(t, err)
+}
+
+func TestSortByScheme(t *testing.T) {
+	tags := testTags(
+		"latest",
+		"2026.10.17-aaaaaaa",
+		"main-20261018T1200-bbbbbbb",
+		"2026.10.18.2-ccccccc",
+		"2026.10.18-ddddddd",
+		"main-20261018T0900-eeeeeee",
+		"sha-fffffff",
+	)
+	scheme := AnyScheme(CalVerScheme, TimestampedShaScheme, GitShaScheme)
+	sorted := SortByScheme(tags, scheme)
+	names := make([]string, 0, len(sorted))
+	for _, s := range sorted {
+		names = append(names, s.Tag.Tag())
+	}
+	require.Equal(t, []string{
+		"main-20261018T1200-bbbbbbb",
+		"main-20261018T0900-eeeeeee",
+		"2026.10.18.2-ccccccc",
+		"2026.10.18-ddddddd",
+		"2026.10.17-aaaaaaa",
+		"sha-fffffff",
+	}, names)
+
+	latest, err := LatestByScheme(tags, CalVerScheme)
+	require.NoError(t, err)
+	require.Equal(t, "2026.10.18.2-ccccccc", latest.Tag.Tag())
+	require.Equal(t, "ccccccc", latest.Version.Sha)
+
+	_, err = LatestByScheme(testTags("latest"), CalVerScheme)
+	require.True(t, errors.Is(err, ErrNoMatchingTag))
+}
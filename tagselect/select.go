@@ -0,0 +1,104 @@
+package tagselect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	containerimagelisting "github.com/cresta/container-image-listing"
+)
+
+// ErrNoMatchingTag is returned when no tag is a version that matches the constraint
+var ErrNoMatchingTag = errors.New("no tag matches constraint")
+
+// Options changes which tags Select picks
+type Options struct {
+	// IncludePrereleases lets pre-releases, like 1.2.3-rc.1, match.  Without it, pre-releases only match constraints
+	// that name a pre-release themselves, like >=2.0.0-rc.1.
+	IncludePrereleases bool
+}
+
+// VersionedTag is a tag with the version parsed from it
+type VersionedTag struct {
+	containerimagelisting.Tag
+	Version *Version
+}
+
+// Sort returns the tags that are semantic versions, newest first.  Tags that are not versions, like latest, are left
+// out.  Tags with the same precedence, like 1.2 and v1.2.0, are ordered by name.
+func Sort(tags []containerimagelisting.Tag) []VersionedTag {
+	ret := make([]VersionedTag, 0, len(tags))
+	for _, t := range tags {
+		v, err := ParseVersion(t.Tag())
+		if err != nil {
+			continue
+		}
+		ret = append(ret, VersionedTag{
+			Tag:     t,
+			Version: v,
+		})
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		if c := ret[i].Version.Compare(ret[j].Version); c != 0 {
+			return c > 0
+		}
+		return ret[i].Tag.Tag() < ret[j].Tag.Tag()
+	})
+	return ret
+}
+
+// Select returns the tags that match constraint, newest first.  An empty constraint matches every version.
+func Select(tags []containerimagelisting.Tag, constraint string, opts Options) ([]VersionedTag, error) {
+	c, err := ParseConstraint(constraint)
+	if err != nil {
+		return nil, err
+	}
+	return SelectConstraint(tags, c, opts), nil
+}
+
+// SelectConstraint is Select with an already parsed constraint
+func SelectConstraint(tags []containerimagelisting.Tag, constraint *Constraint, opts Options) []VersionedTag {
+	sorted := Sort(tags)
+	ret := make([]VersionedTag, 0, len(sorted))
+	for _, t := range sorted {
+		if t.Version.IsPrerelease() && !opts.IncludePrereleases && !constraint.mentionsPrerelease {
+			continue
+		}
+		if constraint.Check(t.Version) {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+// Best returns the newest tag that matches constraint, or ErrNoMatchingTag
+func Best(tags []containerimagelisting.Tag, constraint string, opts Options) (*VersionedTag, error) {
+	matching, err := Select(tags, constraint, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(matching) == 0 {
+		return nil, fmt.Errorf("%w %q", ErrNoMatchingTag, constraint)
+	}
+	return &matching[0], nil
+}
+
+// LatestMatching lists the tags of image with registry, which is usually a *containerimagelisting.RegistryFinder, and
+// returns the newest release that matches constraint.  For example, LatestMatching(ctx, finder, "redis", "^7") returns
+// the newest 7.x.x redis tag.
+func LatestMatching(ctx context.Context, registry containerimagelisting.Registry, image string, constraint string) (*VersionedTag, error) {
+	c, err := ParseConstraint(constraint)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := registry.ListTags(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tags for %s: %w", image, err)
+	}
+	matching := SelectConstraint(tags, c, Options{})
+	if len(matching) == 0 {
+		return nil, fmt.Errorf("%w %q for %s", ErrNoMatchingTag, constraint, image)
+	}
+	return &matching[0], nil
+}
@@ -0,0 +1,93 @@
+package tagselect
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	containerimagelisting "github.com/cresta/container-image-listing"
+	"github.com/stretchr/testify/require"
+)
+
+type testTag string
+
+func (t testTag) Tag() string {
+	return string(t)
+}
+
+func testTags(names ...string) []containerimagelisting.Tag {
+	ret := make([]containerimagelisting.Tag, 0, len(names))
+	for _, n := range names {
+		ret = append(ret, testTag(n))
+	}
+	return ret
+}
+
+func tagNames(tags []VersionedTag) []string {
+	ret := make([]string, 0, len(tags))
+	for _, t := range tags {
+		ret = append(ret, t.Tag.Tag())
+	}
+	return ret
+}
+
+var redisTags = testTags("latest", "6.2.6", "7.0.0-rc.2", "7", "7.0", "7.0.4", "v7.0.4", "7.0.11", "6", "alpine", "7.2-rc1")
+
+func TestSort(t *testing.T) {
+	require.Equal(t, []string{"7.2-rc1", "7.0.11", "7.0.4", "v7.0.4", "7", "7.0", "7.0.0-rc.2", "6.2.6", "6"}, tagNames(Sort(redisTags)))
+}
+
+func TestSelect(t *testing.T) {
+	selected, err := Select(redisTags, "~7.0", Options{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"7.0.11", "7.0.4", "v7.0.4", "7", "7.0"}, tagNames(selected))
+
+	selected, err = Select(redisTags, "^7", Options{IncludePrereleases: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{"7.2-rc1", "7.0.11", "7.0.4", "v7.0.4", "7", "7.0"}, tagNames(selected))
+
+	selected, err = Select(redisTags, ">=7.0.0-rc.1 <7.0.0", Options{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"7.0.0-rc.2"}, tagNames(selected))
+
+	_, err = Select(redisTags, "not a constraint", Options{})
+	require.Error(t, err)
+}
+
+func TestBest(t *testing.T) {
+	best, err := Best(redisTags, "<7", Options{})
+	require.NoError(t, err)
+	require.Equal(t, "6.2.6", best.Tag.Tag())
+	require.Equal(t, "6.2.6", best.Version.String())
+
+	_, err = Best(redisTags, "^8", Options{})
+	require.True(t, errors.Is(err, ErrNoMatchingTag))
+}
+
+type registryFunc func(ctx context.Context, repository string) ([]containerimagelisting.Tag, error)
+
+func (r registryFunc) ListTags(ctx context.Context, repository string) ([]containerimagelisting.Tag, error) {
+	return r(ctx, repository)
+}
+
+func TestLatestMatching(t *testing.T) {
+	registry := registryFunc(func(ctx context.Context, repository string) ([]containerimagelisting.Tag, error) {
+		if repository != "redis" {
+			return nil, containerimagelisting.ErrRepositoryNotFound
+		}
+		return redisTags, nil
+	})
+	ctx := context.Background()
+	latest, err := LatestMatching(ctx, registry, "redis", "")
+	require.NoError(t, err)
+	require.Equal(t, "7.0.11", latest.Tag.Tag())
+
+	latest, err = LatestMatching(ctx, registry, "redis", "6.x")
+	require.NoError(t, err)
+	require.Equal(t, "6.2.6", latest.Tag.Tag())
+
+	_, err = LatestMatching(ctx, registry, "redis", "^9")
+	require.True(t, errors.Is(err, ErrNoMatchingTag))
+	_, err = LatestMatching(ctx, registry, "postgres", "")
+	require.True(t, errors.Is(err, containerimagelisting.ErrRepositoryNotFound))
+}
@@ -0,0 +1,145 @@
+// Package tagselect picks tags by version, like the newest release of an image or the newest 1.x release, from the tags
+// any containerimagelisting.Registry lists.
+package tagselect
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version parsed from a tag
+type Version struct {
+	Major int64
+	Minor int64
+	Patch int64
+	// Prerelease is the dot separated identifiers after the '-', like [rc 1] for 1.2.3-rc.1
+	Prerelease []string
+	// Build is the metadata after the '+', which does not change precedence
+	Build string
+	// parts is how many of major, minor and patch the tag had, so 1.2 can mean any 1.2.x in constraints
+	parts int
+}
+
+// Semver grammar documented at https://semver.org/#backusnaur-form-grammar-for-valid-semver-versions.  Tags are allowed
+// a v prefix and may leave out the minor and patch versions, like v1 or 7.0.
+var versionRegex = regexp.MustCompile(`^[vV]?(0|[1-9]\d*)(?:\.(0|[1-9]\d*))?(?:\.(0|[1-9]\d*))?(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?$`)
+
+// ParseVersion parses a tag like 1.2.3, v1.2.3-rc.1+build.5 or 7.0 as a semantic version
+func ParseVersion(tag string) (*Version, error) {
+	m := versionRegex.FindStringSubmatch(tag)
+	if m == nil {
+		return nil, fmt.Errorf("%q is not a semantic version", tag)
+	}
+	ret := Version{
+		Build: m[5],
+		parts: 1,
+	}
+	nums := []*int64{&ret.Major, &ret.Minor, &ret.Patch}
+	for i, s := range m[1:4] {
+		if s == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q has a version number that is too large: %w", tag, err)
+		}
+		*nums[i] = n
+		ret.parts = i + 1
+	}
+	if m[4] != "" {
+		ret.Prerelease = strings.Split(m[4], ".")
+		for _, id := range ret.Prerelease {
+			if isNumeric(id) && len(id) > 1 && id[0] == '0' {
+				return nil, fmt.Errorf("%q has a numeric pre-release identifier with a leading zero", tag)
+			}
+		}
+	}
+	return &ret, nil
+}
+
+// String returns the version in the canonical major.minor.patch[-prerelease][+build] form, without a v prefix
+func (v *Version) String() string {
+	ret := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		ret += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if v.Build != "" {
+		ret += "+" + v.Build
+	}
+	return ret
+}
+
+// IsPrerelease returns true for versions like 1.2.3-rc.1
+func (v *Version) IsPrerelease() bool {
+	return len(v.Prerelease) > 0
+}
+
+// Compare returns -1, 0 or 1 if v is older than, the same precedence as, or newer than other.  Build metadata is
+// ignored, as semver requires.
+func (v *Version) Compare(other *Version) int {
+	if c := compareInt(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+	return comparePrerelease(v.Prerelease, other.Prerelease)
+}
+
+// comparePrerelease follows https://semver.org/#spec-item-11: a version without a pre-release is newer than one with,
+// and identifiers are compared one by one, numerically if both are numbers.
+func comparePrerelease(a []string, b []string) int {
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		aNum, bNum := isNumeric(a[i]), isNumeric(b[i])
+		switch {
+		case aNum && bNum:
+			an, _ := strconv.ParseInt(a[i], 10, 64)
+			bn, _ := strconv.ParseInt(b[i], 10, 64)
+			if c := compareInt(an, bn); c != 0 {
+				return c
+			}
+		case aNum:
+			// Numeric identifiers are older than alphanumeric ones
+			return -1
+		case bNum:
+			return 1
+		default:
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInt(int64(len(a)), int64(len(b)))
+}
+
+func compareInt(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func isNumeric(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,69 @@
+package tagselect
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersion(t *testing.T) {
+	testFunc := func(given string, expected string, prerelease bool) func(t *testing.T) {
+		return func(t *testing.T) {
+			v, err := ParseVersion(given)
+			require.NoError(t, err)
+			require.Equal(t, expected, v.String())
+			require.Equal(t, prerelease, v.IsPrerelease())
+		}
+	}
+	t.Run("full", testFunc("1.2.3", "1.2.3", false))
+	t.Run("v_prefix", testFunc("v1.2.3", "1.2.3", false))
+	t.Run("partial", testFunc("7.0", "7.0.0", false))
+	t.Run("major_only", testFunc("v18", "18.0.0", false))
+	t.Run("prerelease", testFunc("1.2.3-rc.1", "1.2.3-rc.1", true))
+	t.Run("build", testFunc("1.2.3+build.5", "1.2.3+build.5", false))
+	t.Run("prerelease_and_build", testFunc("v2.0.0-beta.2+sha.abc", "2.0.0-beta.2+sha.abc", true))
+
+	for _, invalid := range []string{"latest", "", "1.2.3.4", "01.2.3", "1.2.3-rc.01", "1.2.3-", "main-abc1234", "v"} {
+		_, err := ParseVersion(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestVersion_Compare(t *testing.T) {
+	// Ordered oldest to newest, from https://semver.org/#spec-item-11
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.2.0",
+		"1.10.0",
+		"2.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, err := ParseVersion(ordered[i])
+			require.NoError(t, err)
+			b, err := ParseVersion(ordered[j])
+			require.NoError(t, err)
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			require.Equal(t, expected, a.Compare(b), "%s vs %s", ordered[i], ordered[j])
+		}
+	}
+
+	a, err := ParseVersion("1.2.3+build.1")
+	require.NoError(t, err)
+	b, err := ParseVersion("v1.2.3+build.2")
+	require.NoError(t, err)
+	require.Equal(t, 0, a.Compare(b))
+}